@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Escopos de autorização. admin inclui todos os outros.
+const (
+	ScopeSubmit = "submit"
+	ScopeRead   = "read"
+	ScopeCancel = "cancel"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+var validScopes = map[string]bool{ScopeSubmit: true, ScopeRead: true, ScopeCancel: true, ScopeDelete: true, ScopeAdmin: true}
+
+// Principal é a identidade autenticada da requisição. Tenant vazio significa
+// acesso a todos os tenants.
+type Principal struct {
+	Subject string
+	Tenant  string
+	Scopes  []string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess indica se o principal pode ver ou alterar jobs do tenant.
+func (p *Principal) CanAccess(tenant string) bool {
+	return p.Tenant == "" || p.Tenant == tenant
+}
+
+// scopeRequest restringe o request ao tenant do principal: credenciais de um
+// tenant só criam jobs para ele, no layout configurado para o tenant, sem
+// templates de saída vindos do request.
+func (p *Principal) scopeRequest(req *ConvertRequest) {
+	if p.Tenant == "" {
+		return
+	}
+	req.Tenant = p.Tenant
+	req.RenditionTemplate, req.MasterTemplate = "", ""
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func principalFromContext(ctx context.Context) *Principal {
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok {
+		return p
+	}
+	return &Principal{}
+}
+
+// apiKey é uma chave estática de AUTH_KEYS_FILE. Só o SHA-256 da chave fica
+// no arquivo: gere com `printf %s "$KEY" | sha256sum`.
+type apiKey struct {
+	Name   string   `json:"name"`
+	SHA256 string   `json:"sha256"`
+	Scopes []string `json:"scopes"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+type authConfig struct {
+	APIKeys []apiKey `json:"api_keys"`
+}
+
+// Authenticator valida API keys (header X-API-Key ou Authorization: Bearer)
+// e JWT assinados por uma chave do JWKS. Sem AUTH_KEYS_FILE e sem JWKS
+// configurados, a autenticação fica desabilitada.
+type Authenticator struct {
+	keys        map[string]apiKey
+	jwks        *JWKS
+	parser      *jwt.Parser
+	tenantClaim string
+}
+
+func NewAuthenticator() (*Authenticator, error) {
+	a := &Authenticator{
+		keys:        make(map[string]apiKey),
+		tenantClaim: envOrDefault("JWT_TENANT_CLAIM", "tenant"),
+	}
+
+	if path := os.Getenv("AUTH_KEYS_FILE"); path != "" {
+		if err := a.loadKeys(path); err != nil {
+			return nil, err
+		}
+	}
+
+	jwksURL, jwksFile := os.Getenv("JWT_JWKS_URL"), os.Getenv("JWT_JWKS_FILE")
+	if jwksURL != "" || jwksFile != "" {
+		jwks, err := NewJWKS(jwksURL, jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = jwks
+
+		opts := []jwt.ParserOption{
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(30 * time.Second),
+		}
+		if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+			opts = append(opts, jwt.WithIssuer(iss))
+		}
+		if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+			opts = append(opts, jwt.WithAudience(aud))
+		}
+		a.parser = jwt.NewParser(opts...)
+	}
+
+	if !a.Enabled() {
+		slog.Warn("Autenticação desabilitada: configure AUTH_KEYS_FILE ou JWT_JWKS_URL/JWT_JWKS_FILE", "component", "auth")
+	}
+	return a, nil
+}
+
+func (a *Authenticator) loadKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("erro ao interpretar %s: %w", path, err)
+	}
+	for _, k := range cfg.APIKeys {
+		hash := strings.ToLower(k.SHA256)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("api key %q: sha256 inválido", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if !validScopes[s] {
+				return fmt.Errorf("api key %q: escopo desconhecido %q", k.Name, s)
+			}
+		}
+		a.keys[hash] = k
+	}
+	return nil
+}
+
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0 || a.jwks != nil
+}
+
+// Require exige uma credencial válida com o escopo informado e guarda o
+// Principal no contexto da requisição.
+func (a *Authenticator) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			next(w, r.WithContext(withPrincipal(r.Context(), &Principal{Subject: "anonymous", Scopes: []string{ScopeAdmin}})))
+			return
+		}
+
+		p, err := a.authenticate(r)
+		if err != nil {
+			slog.Warn("Requisição não autenticada", "component", "auth", "path", r.URL.Path, "error", err.Error())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Credencial ausente ou inválida"})
+			return
+		}
+		if !p.HasScope(scope) {
+			slog.Warn("Escopo insuficiente", "component", "auth", "path", r.URL.Path, "subject", p.Subject, "scope", scope)
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "Escopo necessário: " + scope})
+			return
+		}
+		next(w, r.WithContext(withPrincipal(r.Context(), p)))
+	}
+}
+
+var errNoCredential = errors.New("credencial ausente")
+
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			token = strings.TrimSpace(auth[7:])
+		}
+	}
+	if token == "" {
+		return nil, errNoCredential
+	}
+
+	// JWT tem três partes separadas por ponto; API keys não.
+	if strings.Count(token, ".") == 2 && a.jwks != nil {
+		return a.authenticateJWT(r.Context(), token)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	k, ok := a.keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, errors.New("api key desconhecida")
+	}
+	return &Principal{Subject: "key:" + k.Name, Tenant: k.Tenant, Scopes: k.Scopes}, nil
+}
+
+func (a *Authenticator) authenticateJWT(ctx context.Context, raw string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.jwks.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims.GetSubject()
+	tenant, _ := claims[a.tenantClaim].(string)
+
+	// "scope" (string separada por espaços, RFC 8693) ou "scopes" (lista).
+	var scopes []string
+	if s, ok := claims["scope"].(string); ok {
+		scopes = strings.Fields(s)
+	}
+	if list, ok := claims["scopes"].([]interface{}); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	p := &Principal{Subject: "jwt:" + sub, Tenant: tenant, Scopes: scopes}
+
+	// Um token sem a claim de tenant teria acesso a todos os tenants; isso só
+	// vale para tokens emitidos explicitamente com o escopo admin.
+	if tenant == "" && !p.HasScope(ScopeAdmin) {
+		return nil, fmt.Errorf("JWT sem a claim %q só é aceito com o escopo %s", a.tenantClaim, ScopeAdmin)
+	}
+	return p, nil
+}
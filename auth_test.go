@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// rsaTestKey gera uma única chave para todos os testes; RSA é lento de gerar.
+func rsaTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKey = key
+	})
+	return testKey
+}
+
+// jwksJSON monta um JWKS com a chave pública publicada em cada kid.
+func jwksJSON(key *rsa.PrivateKey, kids ...string) []byte {
+	var keys []jwk
+	for _, kid := range kids {
+		keys = append(keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, _ := json.Marshal(map[string][]jwk{"keys": keys})
+	return data
+}
+
+func signToken(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(rsaTestKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestAuthenticator configura um JWKS em arquivo com o kid "k1" e as API
+// keys informadas.
+func newTestAuthenticator(t *testing.T, keys ...apiKey) *Authenticator {
+	t.Helper()
+	dir := t.TempDir()
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksFile, jwksJSON(rsaTestKey(t), "k1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_JWKS_URL", "")
+	t.Setenv("JWT_JWKS_FILE", jwksFile)
+	t.Setenv("JWT_ISSUER", "")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("JWT_TENANT_CLAIM", "")
+	t.Setenv("AUTH_KEYS_FILE", "")
+	if len(keys) > 0 {
+		keysFile := filepath.Join(dir, "keys.json")
+		data, _ := json.Marshal(authConfig{APIKeys: keys})
+		if err := os.WriteFile(keysFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("AUTH_KEYS_FILE", keysFile)
+	}
+
+	a, err := NewAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// call executa uma requisição protegida pelo escopo e retorna o status e o
+// principal que chegou ao handler.
+func call(a *Authenticator, scope, credential string) (int, *Principal) {
+	var got *Principal
+	h := a.Require(scope, func(w http.ResponseWriter, r *http.Request) {
+		got = principalFromContext(r.Context())
+	})
+	r := httptest.NewRequest(http.MethodPost, "/convert", nil)
+	if credential != "" {
+		r.Header.Set("Authorization", "Bearer "+credential)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w.Code, got
+}
+
+func TestRequireJWT(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	tests := []struct {
+		name       string
+		scope      string
+		token      string
+		wantStatus int
+		wantTenant string
+	}{
+		{"tenant e escopo", ScopeSubmit, signToken(t, "k1", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scope": "submit read"}), http.StatusOK, "acme"},
+		{"lista de escopos", ScopeRead, signToken(t, "k1", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scopes": []string{"read"}}), http.StatusOK, "acme"},
+		{"escopo insuficiente", ScopeDelete, signToken(t, "k1", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scope": "submit read"}), http.StatusForbidden, ""},
+		{"sem tenant e sem admin", ScopeSubmit, signToken(t, "k1", jwt.MapClaims{"sub": "u1", "scope": "submit"}), http.StatusUnauthorized, ""},
+		{"sem tenant com admin", ScopeSubmit, signToken(t, "k1", jwt.MapClaims{"sub": "ops", "scope": "admin"}), http.StatusOK, ""},
+		{"kid desconhecido", ScopeSubmit, signToken(t, "k2", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scope": "submit"}), http.StatusUnauthorized, ""},
+		{"expirado", ScopeSubmit, signToken(t, "k1", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scope": "submit", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized, ""},
+		{"sem credencial", ScopeSubmit, "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		status, p := call(a, tt.scope, tt.token)
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.wantStatus)
+			continue
+		}
+		if status == http.StatusOK && p.Tenant != tt.wantTenant {
+			t.Errorf("%s: tenant = %q, want %q", tt.name, p.Tenant, tt.wantTenant)
+		}
+	}
+}
+
+func TestRequireJWTTenantClaim(t *testing.T) {
+	a := newTestAuthenticator(t)
+	a.tenantClaim = "org"
+
+	token := signToken(t, "k1", jwt.MapClaims{"sub": "u1", "org": "acme", "scope": "submit"})
+	if status, p := call(a, ScopeSubmit, token); status != http.StatusOK || p.Tenant != "acme" {
+		t.Errorf("claim configurada: status = %d, principal = %+v", status, p)
+	}
+	// A claim padrão não vale quando outra foi configurada.
+	token = signToken(t, "k1", jwt.MapClaims{"sub": "u1", "tenant": "acme", "scope": "submit"})
+	if status, _ := call(a, ScopeSubmit, token); status != http.StatusUnauthorized {
+		t.Errorf("claim padrão: status = %d, want 401", status)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	a := newTestAuthenticator(t,
+		apiKey{Name: "acme-ci", SHA256: sha256Hex("acme-secret"), Scopes: []string{ScopeSubmit}, Tenant: "acme"},
+		apiKey{Name: "ops", SHA256: sha256Hex("ops-secret"), Scopes: []string{ScopeAdmin}},
+	)
+
+	if status, p := call(a, ScopeSubmit, "acme-secret"); status != http.StatusOK || p.Tenant != "acme" || p.Subject != "key:acme-ci" {
+		t.Errorf("acme-secret: status = %d, principal = %+v", status, p)
+	}
+	if status, _ := call(a, ScopeDelete, "acme-secret"); status != http.StatusForbidden {
+		t.Errorf("acme-secret sem escopo delete: status = %d, want 403", status)
+	}
+	if status, p := call(a, ScopeDelete, "ops-secret"); status != http.StatusOK || p.Tenant != "" {
+		t.Errorf("ops-secret: status = %d, principal = %+v", status, p)
+	}
+	if status, _ := call(a, ScopeSubmit, "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("chave desconhecida: status = %d, want 401", status)
+	}
+}
+
+func TestLoadKeysRejectsInvalid(t *testing.T) {
+	for _, k := range []apiKey{
+		{Name: "hash curto", SHA256: "abc", Scopes: []string{ScopeRead}},
+		{Name: "escopo", SHA256: sha256Hex("x"), Scopes: []string{"write"}},
+	} {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		data, _ := json.Marshal(authConfig{APIKeys: []apiKey{k}})
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		a := &Authenticator{keys: make(map[string]apiKey)}
+		if err := a.loadKeys(path); err == nil {
+			t.Errorf("%s: loadKeys aceitou a chave", k.Name)
+		}
+	}
+}
+
+func TestPrincipalScopeRequest(t *testing.T) {
+	req := ConvertRequest{Tenant: "other", RenditionTemplate: "x/{media_id}/{quality}", MasterTemplate: "x/{media_id}.m3u8"}
+	(&Principal{Tenant: "acme"}).scopeRequest(&req)
+	if req.Tenant != "acme" || req.RenditionTemplate != "" || req.MasterTemplate != "" {
+		t.Errorf("request de tenant = %+v", req)
+	}
+
+	req = ConvertRequest{Tenant: "other", MasterTemplate: "x/{media_id}.m3u8"}
+	(&Principal{}).scopeRequest(&req)
+	if req.Tenant != "other" || req.MasterTemplate == "" {
+		t.Errorf("request global = %+v", req)
+	}
+
+	if p := (&Principal{Tenant: "acme"}); !p.CanAccess("acme") || p.CanAccess("other") {
+		t.Error("CanAccess de tenant")
+	}
+	if p := (&Principal{}); !p.CanAccess("acme") {
+		t.Error("CanAccess global")
+	}
+}
+
+func TestJWKSRefreshOnUnknownKid(t *testing.T) {
+	key := rsaTestKey(t)
+	var fetches atomic.Int32
+	var body atomic.Value
+	body.Store(jwksJSON(key, "k1"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write(body.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	j, err := NewJWKS(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("fetches = %d, want 1", fetches.Load())
+	}
+
+	// Rotação: k2 passa a existir. Libera a recarga como se o último minuto
+	// tivesse passado.
+	body.Store(jwksJSON(key, "k1", "k2"))
+	j.mu.Lock()
+	j.tried = time.Time{}
+	j.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := j.Key(context.Background(), "k2"); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed.Load() != 0 {
+		t.Errorf("%d lookups de k2 falharam", failed.Load())
+	}
+	// As requisições concorrentes compartilham uma única recarga.
+	if fetches.Load() != 2 {
+		t.Errorf("fetches = %d, want 2", fetches.Load())
+	}
+
+	// Outro kid desconhecido dentro do intervalo mínimo não gera requisição.
+	if _, err := j.Key(context.Background(), "forged"); err == nil {
+		t.Error("kid forjado aceito")
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("fetches após kid forjado = %d, want 2", fetches.Load())
+	}
+}
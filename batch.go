@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	BatchStateRunning   = "running"
+	BatchStateCompleted = "completed"
+
+	// JobStateUnknown marca itens cujo job não está ativo nem no histórico
+	// (descartado pela retenção ou devolvido sem QUEUE_STATE_FILE).
+	JobStateUnknown = "unknown"
+)
+
+// BatchItem é um request do lote: aceito (com conversion_id) ou recusado
+// (com o erro).
+type BatchItem struct {
+	Index        int    `json:"index"`
+	MediaFileID  int    `json:"media_file_id"`
+	ConversionID string `json:"conversion_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+	State        string `json:"state,omitempty"`
+}
+
+// Batch é um lote criado por POST /api/hls/batch.
+type Batch struct {
+	ID          string      `json:"batch_id"`
+	Tenant      string      `json:"tenant,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	Items       []BatchItem `json:"items"`
+}
+
+// Rejected conta os itens recusados na submissão.
+func (b *Batch) Rejected() int {
+	n := 0
+	for _, item := range b.Items {
+		if item.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// BatchResponse é a resposta de POST /api/hls/batch.
+type BatchResponse struct {
+	BatchID  string      `json:"batch_id,omitempty"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Message  string      `json:"message"`
+	Items    []BatchItem `json:"items"`
+}
+
+// BatchStatus é o progresso agregado do lote. Jobs conta os jobs aceitos por
+// estado e Qualities as qualidades por resultado; Progress é a porcentagem de
+// qualidades já encerradas (concluídas, com falha, existentes ou canceladas).
+type BatchStatus struct {
+	BatchID     string         `json:"batch_id"`
+	Tenant      string         `json:"tenant,omitempty"`
+	State       string         `json:"state"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	Total       int            `json:"total"`
+	Rejected    int            `json:"rejected"`
+	Finished    int            `json:"finished"`
+	Progress    float64        `json:"progress"`
+	Jobs        map[string]int `json:"jobs"`
+	Qualities   map[string]int `json:"qualities"`
+	Items       []BatchItem    `json:"items"`
+}
+
+// BatchCallbackPayload é enviado ao callback do tenant quando todos os jobs
+// do lote terminam.
+type BatchCallbackPayload struct {
+	Status string `json:"status"`
+	BatchStatus
+}
+
+func getBatchMaxItems() int {
+	if n := getEnvInt("BATCH_MAX_ITEMS", 1000); n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// getBatchMaxBodyBytes é o tamanho máximo do corpo de POST /api/hls/batch
+// (BATCH_MAX_BODY_MB).
+func getBatchMaxBodyBytes() int64 {
+	if n := getEnvInt("BATCH_MAX_BODY_MB", 16); n > 0 {
+		return int64(n) << 20
+	}
+	return 16 << 20
+}
+
+// decodeBatch lê um array JSON de ConvertRequest ou NDJSON (um request por
+// linha), item a item, recusando o lote assim que passar de maxItems itens.
+func decodeBatch(body io.Reader, maxItems int) ([]ConvertRequest, error) {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(br)
+	var reqs []ConvertRequest
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	for dec.More() {
+		if len(reqs) == maxItems {
+			return nil, fmt.Errorf("lote com mais de %d itens", maxItems)
+		}
+		var req ConvertRequest
+		if err := dec.Decode(&req); err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(reqs), err)
+		}
+		reqs = append(reqs, req)
+	}
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return reqs, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// BatchStore guarda os lotes. Com BATCH_STATE_FILE os lotes sobrevivem a
+// restarts, junto com os jobs de QUEUE_STATE_FILE e o histórico de
+// JOB_HISTORY_FILE. Lotes concluídos seguem a retenção do histórico.
+type BatchStore struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	batches   map[string]*Batch
+}
+
+func NewBatchStore() *BatchStore {
+	s := &BatchStore{
+		path:      os.Getenv("BATCH_STATE_FILE"),
+		retention: getHistoryRetention(),
+		batches:   make(map[string]*Batch),
+	}
+	if err := s.load(); err != nil {
+		slog.Error("Erro ao carregar lotes", "component", "batch", "path", s.path, "error", err.Error())
+	}
+	return s
+}
+
+func (s *BatchStore) Add(b *Batch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[b.ID] = b
+	s.prune()
+	s.persist()
+}
+
+// Get retorna uma cópia do lote.
+func (s *BatchStore) Get(id string) (Batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	if !ok {
+		return Batch{}, false
+	}
+	c := *b
+	c.Items = append([]BatchItem(nil), b.Items...)
+	return c, true
+}
+
+// MarkCompleted registra o término do lote e retorna false se ele já estava
+// registrado, para que o callback saia uma única vez.
+func (s *BatchStore) MarkCompleted(id string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	if !ok || b.CompletedAt != nil {
+		return false
+	}
+	b.CompletedAt = &at
+	s.persist()
+	return true
+}
+
+// Reopen desfaz o término do lote quando um job dele é repetido via retry; o
+// próximo término é registrado e notificado de novo.
+func (s *BatchStore) Reopen(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.batches[id]; ok && b.CompletedAt != nil {
+		b.CompletedAt = nil
+		s.persist()
+	}
+}
+
+// prune descarta lotes concluídos há mais que a retenção. Deve ser chamado
+// com s.mu travado.
+func (s *BatchStore) prune() {
+	cutoff := time.Now().Add(-s.retention)
+	for id, b := range s.batches {
+		if b.CompletedAt != nil && b.CompletedAt.Before(cutoff) {
+			delete(s.batches, id)
+		}
+	}
+}
+
+func (s *BatchStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", s.path, err)
+	}
+	if err := json.Unmarshal(data, &s.batches); err != nil {
+		return fmt.Errorf("erro ao interpretar %s: %w", s.path, err)
+	}
+	s.prune()
+	return nil
+}
+
+// persist grava os lotes em BATCH_STATE_FILE. Deve ser chamado com s.mu
+// travado.
+func (s *BatchStore) persist() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.batches)
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(s.path), 0755); err == nil {
+			tmp := s.path + ".tmp"
+			if err = os.WriteFile(tmp, data, 0644); err == nil {
+				err = os.Rename(tmp, s.path)
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("Erro ao persistir lotes", "component", "batch", "path", s.path, "error", err.Error())
+	}
+}
+
+// BatchStatus agrega o estado dos jobs do lote, ativos ou no histórico.
+func (q *JobQueue) BatchStatus(id string) (BatchStatus, bool) {
+	b, ok := q.batches.Get(id)
+	if !ok {
+		return BatchStatus{}, false
+	}
+
+	st := BatchStatus{
+		BatchID:     b.ID,
+		Tenant:      b.Tenant,
+		State:       BatchStateRunning,
+		CreatedAt:   b.CreatedAt,
+		CompletedAt: b.CompletedAt,
+		Rejected:    b.Rejected(),
+		Jobs:        make(map[string]int),
+		Qualities:   make(map[string]int),
+		Items:       b.Items,
+	}
+
+	requested, done := 0, 0
+	for i := range st.Items {
+		item := &st.Items[i]
+		if item.ConversionID == "" {
+			continue
+		}
+		st.Total++
+
+		if status, ok := q.Status(item.ConversionID); ok {
+			// Na fila ou em processamento: as qualidades ainda não
+			// reportadas contam como pendentes.
+			item.State = status.State
+			reported := len(status.CompletedQualities) + len(status.FailedQualities)
+			pending := q.pendingQualities(item.ConversionID)
+			addCount(st.Qualities, QualityCompleted, len(status.CompletedQualities))
+			addCount(st.Qualities, QualityFailed, len(status.FailedQualities))
+			addCount(st.Qualities, QualityPending, pending)
+			requested += reported + pending
+			done += reported
+		} else if rec, ok := q.Record(item.ConversionID); ok {
+			item.State = rec.State
+			st.Finished++
+			listed := make(map[string]bool)
+			for _, o := range rec.Qualities {
+				listed[o.Quality] = true
+				st.Qualities[o.Status]++
+				requested++
+				if o.Status != QualityPending {
+					done++
+				}
+			}
+			// Num retry, o request traz só as qualidades repetidas; as
+			// concluídas antes continuam contando.
+			for _, quality := range rec.CompletedQualities {
+				if !listed[quality] {
+					st.Qualities[QualityCompleted]++
+					requested++
+					done++
+				}
+			}
+		} else {
+			item.State = JobStateUnknown
+			st.Finished++
+		}
+		st.Jobs[item.State]++
+	}
+
+	if requested > 0 {
+		st.Progress = math.Round(float64(done)/float64(requested)*1000) / 10
+	}
+	if st.Finished == st.Total {
+		st.State = BatchStateCompleted
+		st.Progress = 100
+	}
+	return st, true
+}
+
+// pendingQualities conta as qualidades de um job ativo ainda não reportadas.
+func (q *JobQueue) pendingQualities(conversionID string) int {
+	q.mu.RLock()
+	job, ok := q.active[conversionID]
+	q.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return len(remainingQualities(job))
+}
+
+func addCount(m map[string]int, key string, n int) {
+	if n > 0 {
+		m[key] += n
+	}
+}
+
+// completeBatch registra o término do lote, se todos os jobs dele terminaram,
+// e envia o callback "batch_completed" com o resumo. É chamado ao fim de cada
+// job do lote e na criação do lote, caso os jobs terminem antes de ele ser
+// registrado.
+func (q *JobQueue) completeBatch(batchID string) {
+	st, ok := q.BatchStatus(batchID)
+	if !ok || st.State != BatchStateCompleted {
+		return
+	}
+
+	now := time.Now()
+	if !q.batches.MarkCompleted(batchID, now) {
+		return
+	}
+	st.CompletedAt = &now
+
+	logger := slog.With("component", "batch", "batch_id", batchID)
+	logger.Info("Lote concluído", "jobs", st.Total, "states", st.Jobs)
+
+	req := ConvertRequest{Tenant: st.Tenant, BatchID: st.BatchID}
+	sendEvent(withLogger(context.Background(), logger), callbackFor(req), newEvent(EventBatchCompleted, "", req, st),
+		BatchCallbackPayload{Status: "batch_completed", BatchStatus: st})
+}
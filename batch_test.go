@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []int
+		wantErr string
+	}{
+		{"array", `[{"media_file_id":1},{"media_file_id":2}]`, []int{1, 2}, ""},
+		{"array com espaços", " \n [ {\"media_file_id\":1} ] \n", []int{1}, ""},
+		{"array vazio", `[]`, nil, ""},
+		{"ndjson", "{\"media_file_id\":1}\n{\"media_file_id\":2}\n{\"media_file_id\":3}\n", []int{1, 2, 3}, ""},
+		{"corpo vazio", "  \n", nil, ""},
+		{"array no limite", `[{"media_file_id":1},{"media_file_id":2},{"media_file_id":3}]`, []int{1, 2, 3}, ""},
+		{"array acima do limite", `[{"media_file_id":1},{"media_file_id":2},{"media_file_id":3},{"media_file_id":4}]`, nil, "lote com mais de 3 itens"},
+		{"ndjson acima do limite", "{}\n{}\n{}\n{}\n", nil, "lote com mais de 3 itens"},
+		{"item inválido", `[{"media_file_id":1},{"media_file_id":"x"}]`, nil, "item 1"},
+		{"array sem fechamento", `[{"media_file_id":1}`, nil, "unexpected"},
+	}
+	for _, tt := range tests {
+		reqs, err := decodeBatch(strings.NewReader(tt.body), 3)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		var ids []int
+		for _, r := range reqs {
+			ids = append(ids, r.MediaFileID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: ids = %v, want %v", tt.name, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: ids = %v, want %v", tt.name, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// failingReader entrega o conteúdo e depois falha, como um corpo que não
+// deveria ser lido até o fim.
+type failingReader struct{ r io.Reader }
+
+var errReadPastLimit = errors.New("leu além do limite")
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errReadPastLimit
+	}
+	return n, err
+}
+
+func TestDecodeBatchStopsAtLimit(t *testing.T) {
+	// Quatro itens completos e um resto que não pode ser lido: o lote é
+	// recusado no quarto item, sem decodificar o array inteiro.
+	body := &failingReader{strings.NewReader(`[{"media_file_id":1},{"media_file_id":2},{"media_file_id":3},{"media_file_id":4},`)}
+	_, err := decodeBatch(body, 3)
+	if err == nil || errors.Is(err, errReadPastLimit) || !strings.Contains(err.Error(), "lote com mais de 3 itens") {
+		t.Errorf("err = %v, want limite de itens", err)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var ErrLayoutNotDeletable = errors.New("o layout de saída depende de variáveis da conversão ({conversion_id}, {date}, {title_slug}) ou não usa {media_id}; não é possível localizar só as renditions da mídia")
+
+// Variáveis que podem ser renderizadas sem o job que gerou as renditions.
+var stableTemplateVars = map[string]bool{"{media_id}": true, "{tenant}": true, "{quality}": true}
+
+func isStableTemplate(tmpl string) bool {
+	for _, v := range templateVar.FindAllString(tmpl, -1) {
+		if !stableTemplateVars[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteResult resume uma remoção de renditions.
+type DeleteResult struct {
+	MediaFileID    int      `json:"media_file_id"`
+	Quality        string   `json:"quality,omitempty"`
+	DeletedObjects int      `json:"deleted_objects"`
+	Prefixes       []string `json:"prefixes"`
+}
+
+// isMediaTemplate indica se o template gera chaves exclusivas da mídia.
+func isMediaTemplate(tmpl string) bool {
+	return isStableTemplate(tmpl) && strings.Contains(tmpl, "{media_id}")
+}
+
+// deleteMediaOutputs remove as saídas da mídia gravadas pelo serviço: todas,
+// ou só as de quality. Só são apagados os diretórios das renditions, a master
+// playlist e o manifest; outros objetos ao lado da master ficam. No modo
+// versioned o manifest indica os prefixos de cada versão; no modo in_place
+// os prefixos são renderizados a partir dos templates, que então precisam
+// usar apenas {media_id}, {tenant} e {quality}.
+func deleteMediaOutputs(ctx context.Context, req ConvertRequest, quality string) (DeleteResult, error) {
+	result := DeleteResult{MediaFileID: req.MediaFileID, Quality: quality, Prefixes: []string{}}
+
+	rendition, master := outputTemplates(req)
+	if !isMediaTemplate(master) {
+		return result, ErrLayoutNotDeletable
+	}
+	layout := outputLayout(&ConversionJob{Request: req})
+
+	_, output, err := jobStorages(req)
+	if err != nil {
+		return result, err
+	}
+	tempDir, err := os.MkdirTemp(getTempDir(), "delete-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	mKey := manifestKey(layout)
+	manifest, err := readManifest(ctx, output, tempDir, mKey)
+	if err != nil {
+		return result, err
+	}
+	if manifest == nil && !isMediaTemplate(rendition) {
+		return result, ErrLayoutNotDeletable
+	}
+
+	if quality != "" {
+		return deleteQualityOutputs(ctx, output, tempDir, layout, manifest, quality, result)
+	}
+
+	var prefixes []string
+	if manifest != nil {
+		prefixes = append(prefixes, manifest.Prefixes...)
+		for _, r := range manifest.Retired {
+			prefixes = append(prefixes, r.Prefixes...)
+		}
+	}
+	if isMediaTemplate(rendition) {
+		for q := range QualityMap {
+			prefixes = append(prefixes, layout.RenditionDir(q))
+		}
+	}
+
+	for _, prefix := range collapsePrefixes(prefixes) {
+		n, err := output.DeletePrefix(ctx, prefix)
+		result.DeletedObjects += n
+		if err != nil {
+			return result, err
+		}
+		result.Prefixes = append(result.Prefixes, prefix)
+	}
+	for _, key := range []string{layout.MasterPlaylist(), mKey} {
+		if exists, _ := output.Exists(ctx, key); exists {
+			if err := output.Delete(ctx, key); err != nil {
+				return result, err
+			}
+			result.DeletedObjects++
+		}
+	}
+	return result, nil
+}
+
+// deleteQualityOutputs remove uma qualidade e regrava a master playlist sem
+// ela (ou a remove, se era a última).
+func deleteQualityOutputs(ctx context.Context, output Storage, tempDir string, layout OutputLayout, manifest *publishManifest, quality string, result DeleteResult) (DeleteResult, error) {
+	remaining := make(map[string]string)
+	prefix := layout.RenditionDir(quality)
+
+	if manifest != nil {
+		playlist, ok := manifest.Renditions[quality]
+		if !ok {
+			return result, nil
+		}
+		prefix = path.Dir(playlist)
+		delete(manifest.Renditions, quality)
+		manifest.Prefixes = removeString(manifest.Prefixes, prefix)
+		for q, p := range manifest.Renditions {
+			remaining[q] = p
+		}
+	} else {
+		for q := range QualityMap {
+			if q == quality {
+				continue
+			}
+			if exists, err := output.Exists(ctx, layout.RenditionPlaylist(q)); err == nil && exists {
+				remaining[q] = layout.RenditionPlaylist(q)
+			}
+		}
+	}
+
+	n, err := output.DeletePrefix(ctx, prefix)
+	result.DeletedObjects += n
+	if err != nil {
+		return result, err
+	}
+	result.Prefixes = append(result.Prefixes, prefix)
+
+	masterKey := layout.MasterPlaylist()
+	if len(remaining) == 0 {
+		keys := []string{masterKey}
+		if manifest != nil {
+			keys = append(keys, manifestKey(layout))
+		}
+		for _, key := range keys {
+			if exists, _ := output.Exists(ctx, key); exists {
+				if err := output.Delete(ctx, key); err != nil {
+					return result, err
+				}
+				result.DeletedObjects++
+			}
+		}
+		return result, nil
+	}
+
+	if err := uploadMasterPlaylist(ctx, output, tempDir, masterKey, remaining); err != nil {
+		return result, err
+	}
+	if manifest != nil {
+		if err := writeManifest(ctx, output, tempDir, manifestKey(layout), manifest); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// cleanupPartialUploads remove o que um job cancelado ou com falha chegou a
+// enviar. No modo versioned a versão inteira é descartada, pois nunca será
+// publicada; no modo in_place só as qualidades iniciadas e não concluídas.
+func cleanupPartialUploads(ctx context.Context, output Storage, layout OutputLayout, job *ConversionJob, attempted []string, published, versioned bool) {
+	if !getEnvBool("CLEANUP_PARTIAL_UPLOADS", false) || job.Interrupted.Load() {
+		return
+	}
+
+	job.Mu.Lock()
+	completed := append([]string{}, job.CompletedQualities...)
+	job.Mu.Unlock()
+
+	var qualities []string
+	switch {
+	case versioned && !published:
+		qualities = completed
+		for _, q := range attempted {
+			if !containsString(qualities, q) {
+				qualities = append(qualities, q)
+			}
+		}
+	case !versioned && !job.Superseded.Load():
+		for _, q := range attempted {
+			if !containsString(completed, q) {
+				qualities = append(qualities, q)
+			}
+		}
+	}
+	if len(qualities) == 0 {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	logger := ctxLogger(ctx, "cleanup").With("phase", PhaseCleanup)
+	for _, q := range qualities {
+		prefix := layout.RenditionDir(q)
+		n, err := output.DeletePrefix(ctx, prefix)
+		if err != nil {
+			logger.Error("Erro ao remover uploads parciais", "quality", q, "prefix", prefix, "error", err.Error())
+			continue
+		}
+		logger.Info("Uploads parciais removidos", "quality", q, "prefix", prefix, "objects", n)
+		// A versão é a mesma num retry (conversion_id), então a qualidade
+		// precisa ser codificada de novo.
+		if containsString(completed, q) {
+			job.discardRendition(q)
+		}
+	}
+}
+
+// collapsePrefixes remove duplicatas e prefixos contidos em outros da lista.
+func collapsePrefixes(prefixes []string) []string {
+	var clean []string
+	for _, p := range prefixes {
+		if p = strings.Trim(p, "/"); p != "" && p != "." {
+			clean = append(clean, p)
+		}
+	}
+	sort.Strings(clean)
+	var out []string
+next:
+	for _, p := range clean {
+		// Ordenados, os prefixos que contêm p já estão em out; "-" ordena
+		// antes de "/", então não basta olhar o último.
+		for _, kept := range out {
+			if kept == p || strings.HasPrefix(p, kept+"/") {
+				continue next
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
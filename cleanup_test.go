@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollapsePrefixes(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"hls/123/720p", "hls/123/360p", "hls/123/720p/"}, "hls/123/360p,hls/123/720p"},
+		{[]string{"hls/123/v/a", "hls/123/v/a/720p", "/hls/123/v/b/"}, "hls/123/v/a,hls/123/v/b"},
+		// hls/12 não contém hls/123.
+		{[]string{"hls/12", "hls/123"}, "hls/12,hls/123"},
+		{[]string{"hls/12/a", "hls/12-x", "hls/12"}, "hls/12,hls/12-x"},
+		{[]string{"", "/", "."}, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(collapsePrefixes(tt.in), ","); got != tt.want {
+			t.Errorf("collapsePrefixes(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsMediaTemplate(t *testing.T) {
+	tests := map[string]bool{
+		"hls/{media_id}/{quality}":                   true,
+		"{tenant}/hls/{media_id}/master.m3u8":        true,
+		"hls/{media_id}/v/{conversion_id}/{quality}": false,
+		"hls/{date}/{media_id}/master.m3u8":          false,
+		"hls/{title_slug}-{media_id}/{quality}":      false,
+		"hls/master.m3u8":                            false,
+	}
+	for tmpl, want := range tests {
+		if got := isMediaTemplate(tmpl); got != want {
+			t.Errorf("isMediaTemplate(%q) = %v, want %v", tmpl, got, want)
+		}
+	}
+}
+
+// setupLocalOutput aponta o storage de saída para um diretório temporário e
+// cria nele os arquivos informados.
+func setupLocalOutput(t *testing.T, mode string, files ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("STORAGE_BACKEND", StorageBackendLocal)
+	t.Setenv("LOCAL_STORAGE_ROOT", root)
+	t.Setenv("TEMP_DIR", t.TempDir())
+	t.Setenv("PUBLISH_MODE", mode)
+	withTenants(t, map[string]*Tenant{"acme": {}})
+	for _, f := range files {
+		writeFile(t, filepath.Join(root, f), "x")
+	}
+	return root
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertFiles confere quais dos arquivos existem sob root.
+func assertFiles(t *testing.T, root string, exist bool, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(root, f))
+		if exist && err != nil {
+			t.Errorf("%s deveria existir: %v", f, err)
+		}
+		if !exist && err == nil {
+			t.Errorf("%s deveria ter sido removido", f)
+		}
+	}
+}
+
+func TestDeleteMediaOutputsInPlace(t *testing.T) {
+	root := setupLocalOutput(t, PublishInPlace,
+		"hls/123/720p/master.m3u8", "hls/123/720p/seg_000.ts",
+		"hls/123/360p/master.m3u8", "hls/123/360p/seg_000.ts",
+		"hls/123/master.m3u8", "hls/123/poster.jpg",
+		"hls/1234/720p/master.m3u8", "hls/1234/master.m3u8",
+	)
+
+	res, err := deleteMediaOutputs(context.Background(), ConvertRequest{MediaFileID: 123}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedObjects != 5 {
+		t.Errorf("DeletedObjects = %d, want 5", res.DeletedObjects)
+	}
+	assertFiles(t, root, false, "hls/123/720p", "hls/123/360p", "hls/123/master.m3u8")
+	// Só as saídas do serviço são apagadas, e só da mídia 123.
+	assertFiles(t, root, true, "hls/123/poster.jpg", "hls/1234/720p/master.m3u8", "hls/1234/master.m3u8")
+}
+
+func TestDeleteMediaOutputsVersioned(t *testing.T) {
+	root := setupLocalOutput(t, PublishVersioned,
+		"hls/123/v/new/720p/master.m3u8", "hls/123/v/new/720p/seg_000.ts",
+		"hls/123/v/old/720p/master.m3u8",
+		"hls/123/v/other/720p/master.m3u8",
+		"hls/123/master.m3u8", "hls/123/poster.jpg",
+	)
+	manifest := publishManifest{
+		MediaFileID: 123,
+		Renditions:  map[string]string{"720p": "hls/123/v/new/720p/master.m3u8"},
+		Prefixes:    []string{"hls/123/v/new/720p"},
+		Retired:     []retiredVersion{{Version: "old", Prefixes: []string{"hls/123/v/old/720p"}}},
+	}
+	data, _ := json.Marshal(manifest)
+	writeFile(t, filepath.Join(root, "hls/123/master.json"), string(data))
+
+	if _, err := deleteMediaOutputs(context.Background(), ConvertRequest{MediaFileID: 123}, ""); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, root, false, "hls/123/v/new/720p", "hls/123/v/old/720p", "hls/123/master.m3u8", "hls/123/master.json")
+	// Prefixos fora do manifest não são do serviço.
+	assertFiles(t, root, true, "hls/123/v/other/720p/master.m3u8", "hls/123/poster.jpg")
+}
+
+func TestDeleteMediaOutputsQuality(t *testing.T) {
+	root := setupLocalOutput(t, PublishInPlace,
+		"hls/123/720p/master.m3u8", "hls/123/720p/seg_000.ts",
+		"hls/123/360p/master.m3u8",
+		"hls/123/master.m3u8",
+	)
+
+	if _, err := deleteMediaOutputs(context.Background(), ConvertRequest{MediaFileID: 123}, "720p"); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, root, false, "hls/123/720p")
+	assertFiles(t, root, true, "hls/123/360p/master.m3u8")
+
+	master, err := os.ReadFile(filepath.Join(root, "hls/123/master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(master), "720p") || !strings.Contains(string(master), "360p/master.m3u8") {
+		t.Errorf("master playlist regravada:\n%s", master)
+	}
+}
+
+func TestDeleteMediaOutputsTenantScoped(t *testing.T) {
+	root := setupLocalOutput(t, PublishInPlace,
+		"acme/hls/123/720p/master.m3u8", "acme/hls/123/master.m3u8",
+		"hls/123/720p/master.m3u8", "hls/123/master.m3u8",
+	)
+
+	if _, err := deleteMediaOutputs(context.Background(), ConvertRequest{MediaFileID: 123, Tenant: "acme"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, root, false, "acme/hls/123/720p", "acme/hls/123/master.m3u8")
+	assertFiles(t, root, true, "hls/123/720p/master.m3u8", "hls/123/master.m3u8")
+}
+
+func TestDeleteMediaOutputsRejectsUnstableLayout(t *testing.T) {
+	setupLocalOutput(t, PublishInPlace)
+
+	for _, req := range []ConvertRequest{
+		{MediaFileID: 123, MasterTemplate: "hls/master.m3u8"},
+		{MediaFileID: 123, MasterTemplate: "hls/{date}/{media_id}/master.m3u8"},
+		{MediaFileID: 123, RenditionTemplate: "hls/{media_id}/{conversion_id}/{quality}"},
+	} {
+		if _, err := deleteMediaOutputs(context.Background(), req, ""); !errors.Is(err, ErrLayoutNotDeletable) {
+			t.Errorf("%+v: err = %v, want ErrLayoutNotDeletable", req, err)
+		}
+	}
+}
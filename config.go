@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+func getEnvInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Valor inválido em variável de ambiente, usando padrão", "component", "config", "name", name, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+func getEnvBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Valor inválido em variável de ambiente, usando padrão", "component", "config", "name", name, "value", v, "default", def)
+		return def
+	}
+	return b
+}
@@ -3,17 +3,27 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func getFFmpegPath() string {
@@ -38,54 +48,123 @@ func getCallbackURL() string {
 }
 
 func processJob(job *ConversionJob) {
+	ctx, span := tracer.Start(job.Ctx, "processJob", trace.WithAttributes(jobAttributes(job)...))
+	defer span.End()
+
+	logger := jobLogger(job)
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	ctx = withLogger(ctx, logger)
+	logger = logger.With("component", "converter")
+
 	req := job.Request
 	tempDir := filepath.Join(getTempDir(), job.ID)
-
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		log.Printf("[CONVERTER] Erro ao criar diretório temp %s: %v", tempDir, err)
-		for _, q := range req.Qualities {
-			sendCallback(getCallbackURL(), CallbackPayload{
+	callback := callbackFor(req)
+	layout := outputLayout(job)
+	versioned := publishMode(req) == PublishVersioned
+	jobStart := time.Now()
+
+	// Qualidades a codificar; no modo repair exclui as que já existem.
+	requested := job.qualitiesToProcess()
+	qualities := requested
+	var existing map[string]string
+
+	// failAll reporta a falha de todas as qualidades, exceto quando o job foi
+	// interrompido pelo shutdown (a fila devolve o trabalho) ou substituído
+	// por uma nova submissão.
+	failAll := func(err error) {
+		if job.Interrupted.Load() || job.Superseded.Load() {
+			return
+		}
+		job.Mu.Lock()
+		// Um job retomado de uma pausa mantém as falhas anteriores.
+		job.FailedQualities = append(job.FailedQualities, qualities...)
+		job.Mu.Unlock()
+		for _, q := range qualities {
+			job.recordOutcome(q, QualityFailed, "", err)
+			sendQualityCallback(ctx, callback, job, CallbackPayload{
 				MediaID:      req.MediaFileID,
 				Quality:      q,
 				Status:       "failed",
-				ErrorMessage: fmt.Sprintf("erro ao criar diretório temporário: %v", err),
-			})
+				ErrorMessage: err.Error(),
+				ErrorKind:    errorKind(err),
+			}, false)
 		}
+	}
+
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		logger.Error("Erro ao criar diretório temporário", "phase", PhaseDownload, "temp_dir", tempDir, "error", err.Error())
+		failAll(fmt.Errorf("erro ao criar diretório temporário: %w", err))
 		return
 	}
 	defer func() {
-		log.Printf("[CONVERTER] Limpando diretório temp %s", tempDir)
+		logger.Info("Limpando diretório temporário", "phase", PhaseCleanup, "temp_dir", tempDir)
 		os.RemoveAll(tempDir)
 	}()
 
-	s3c, err := NewS3Client()
+	input, output, err := jobStorages(req)
 	if err != nil {
-		log.Printf("[CONVERTER] Erro ao criar cliente S3: %v", err)
-		for _, q := range req.Qualities {
-			sendCallback(getCallbackURL(), CallbackPayload{
-				MediaID:      req.MediaFileID,
-				Quality:      q,
-				Status:       "failed",
-				ErrorMessage: fmt.Sprintf("erro ao criar cliente S3: %v", err),
-			})
-		}
+		logger.Error("Erro ao criar storage", "phase", PhaseDownload, "error", err.Error())
+		failAll(err)
 		return
 	}
 
-	// Download original file once
-	originalPath := filepath.Join(tempDir, "original"+filepath.Ext(req.S3Path))
-	log.Printf("[CONVERTER] Job %s: Baixando arquivo original de %s", job.ID, req.S3Path)
+	// Com CLEANUP_PARTIAL_UPLOADS, jobs cancelados ou com falha removem o que
+	// chegaram a enviar.
+	var attempted []string
+	published := false
+	defer func() {
+		cleanupPartialUploads(ctx, output, layout, job, attempted, published, versioned)
+	}()
 
-	if err := s3c.Download(job.Ctx, req.S3Path, originalPath); err != nil {
-		log.Printf("[CONVERTER] Erro ao baixar original: %v", err)
-		for _, q := range req.Qualities {
-			sendCallback(getCallbackURL(), CallbackPayload{
-				MediaID:      req.MediaFileID,
-				Quality:      q,
-				Status:       "failed",
-				ErrorMessage: fmt.Sprintf("erro ao baixar arquivo original: %v", err),
-			})
+	if req.Mode == ConvertModeRepair {
+		existing, err = existingRenditions(ctx, output, tempDir, layout, versioned)
+		if err != nil {
+			logger.Error("Erro ao inspecionar renditions existentes", "phase", PhaseDownload, "error", err.Error())
+			failAll(fmt.Errorf("erro ao inspecionar renditions existentes: %w", err))
+			return
+		}
+
+		qualities = nil
+		for _, q := range requested {
+			key, ok := existing[q]
+			if !ok {
+				qualities = append(qualities, q)
+				continue
+			}
+			// O Laravel espera um callback por qualidade pedida.
+			logger.Info("Qualidade já existe, mantendo", "phase", PhaseEncode, "quality", q, "s3_path", key)
+			job.recordOutcome(q, QualityExisting, key, nil)
+			sendQualityCallback(ctx, callback, job, CallbackPayload{
+				MediaID: req.MediaFileID,
+				Quality: q,
+				Status:  "completed",
+				S3Path:  key,
+			}, true)
+		}
+
+		if len(qualities) == 0 {
+			logger.Info("Nenhuma qualidade a reparar", "phase", PhaseEncode)
+			if !versioned && len(existing) > 0 {
+				if err := generateAndUploadMasterPlaylist(ctx, output, tempDir, layout, mapKeys(existing)); err != nil {
+					logger.Error("Erro ao gerar master playlist", "phase", PhasePlaylist, "error", err.Error())
+				} else {
+					job.markPublished()
+				}
+			}
+			return
 		}
+		logger.Info("Reparando qualidades ausentes", "phase", PhaseEncode, "qualities", qualities, "existing", len(existing))
+	}
+
+	// Download original file once (or stream it directly, depending on input mode)
+	downloadStart := time.Now()
+	source, err := prepareInput(ctx, req, input, tempDir)
+	job.recordPhase("", PhaseDownload, time.Since(downloadStart))
+	if err != nil {
+		logger.Error("Erro ao baixar original", "phase", PhaseDownload, seconds(time.Since(downloadStart)), "error", err.Error())
+		failAll(fmt.Errorf("erro ao baixar arquivo original: %w", err))
 		return
 	}
 
@@ -93,33 +172,75 @@ func processJob(job *ConversionJob) {
 	watermarkPath := ""
 	if req.Watermark != nil && req.Watermark.Enabled && req.Watermark.S3Path != "" {
 		watermarkPath = filepath.Join(tempDir, "watermark"+filepath.Ext(req.Watermark.S3Path))
-		log.Printf("[CONVERTER] Job %s: Baixando watermark de %s", job.ID, req.Watermark.S3Path)
-		
-		if err := s3c.Download(job.Ctx, req.Watermark.S3Path, watermarkPath); err != nil {
-			log.Printf("[CONVERTER] Aviso: erro ao baixar watermark: %v", err)
+		logger.Info("Baixando watermark", "phase", PhaseDownload, "key", req.Watermark.S3Path)
+
+		if err := input.Download(ctx, req.Watermark.S3Path, watermarkPath); err != nil {
+			logger.Warn("Erro ao baixar watermark, continuando sem", "phase", PhaseDownload, "error", err.Error())
 			watermarkPath = "" // Continua sem watermark
 		}
 	}
 
+	if !source.streaming {
+		logger.Info("Original disponível", "phase", PhaseDownload, seconds(time.Since(downloadStart)))
+	}
+
 	// Process each quality sequentially
-	for _, quality := range req.Qualities {
+	for _, quality := range qualities {
+		qlogger := logger.With("quality", quality)
+
+		// Pausado durante o download ou o upload da qualidade anterior.
+		job.waitWhilePaused(ctx)
+
 		select {
-		case <-job.Ctx.Done():
-			log.Printf("[CONVERTER] Job %s cancelado antes de processar %s", job.ID, quality)
+		case <-ctx.Done():
+			qlogger.Warn("Job cancelado antes de processar a qualidade", "phase", PhaseEncode)
 			return
 		default:
 		}
 
-		log.Printf("[CONVERTER] Job %s: Iniciando conversão para %s", job.ID, quality)
-		err := convertQuality(job, s3c, originalPath, watermarkPath, tempDir, quality)
+		qualityStart := time.Now()
+		attempted = append(attempted, quality)
+		qlogger.Info("Iniciando conversão", "phase", PhaseEncode)
+		sendEvent(ctx, callback, newEvent(EventQualityStarted, job.ID, req, QualityEventData{Quality: quality}), nil)
+		err := convertFromSource(ctx, job, source, output, watermarkPath, tempDir, quality)
+		if err != nil && source.streaming && ctx.Err() == nil && isStreamReadError(err) {
+			// Fallback: alguns arquivos exigem seeks que não funcionam bem via
+			// HTTP. Baixa o original completo e tenta a qualidade de novo.
+			qlogger.Warn("Falha em streaming, baixando original completo", append([]any{"phase", PhaseDownload}, errAttrs(err)...)...)
+			fallbackStart := time.Now()
+			dlErr := source.download(ctx, tempDir)
+			job.recordPhase(quality, PhaseDownload, time.Since(fallbackStart))
+			if dlErr != nil {
+				err = fmt.Errorf("erro ao baixar arquivo original: %w", dlErr)
+			} else {
+				err = convertFromSource(ctx, job, source, output, watermarkPath, tempDir, quality)
+			}
+		}
+		if err != nil && job.Parked.Load() {
+			qlogger.Info("Conversão interrompida pela pausa, qualidade será refeita no resume", "phase", PhaseEncode, seconds(time.Since(qualityStart)))
+			return
+		}
+		if err != nil && job.Interrupted.Load() {
+			qlogger.Warn("Conversão interrompida pelo shutdown", "phase", PhaseEncode, seconds(time.Since(qualityStart)))
+			return
+		}
+		if err != nil && job.Superseded.Load() {
+			qlogger.Warn("Conversão substituída por nova submissão", "phase", PhaseEncode, seconds(time.Since(qualityStart)))
+			return
+		}
 		if err != nil {
-			log.Printf("[CONVERTER] Job %s: Erro na conversão %s: %v", job.ID, quality, err)
-			sendCallback(getCallbackURL(), CallbackPayload{
+			qlogger.Error("Erro na conversão", append([]any{"phase", PhaseEncode, seconds(time.Since(qualityStart))}, errAttrs(err)...)...)
+			job.Mu.Lock()
+			job.FailedQualities = append(job.FailedQualities, quality)
+			job.Mu.Unlock()
+			job.recordOutcome(quality, QualityFailed, "", err)
+			sendQualityCallback(ctx, callback, job, CallbackPayload{
 				MediaID:      req.MediaFileID,
 				Quality:      quality,
 				Status:       "failed",
 				ErrorMessage: err.Error(),
-			})
+				ErrorKind:    errorKind(err),
+			}, false)
 			continue
 		}
 
@@ -129,41 +250,93 @@ func processJob(job *ConversionJob) {
 		copy(completedQualities, job.CompletedQualities)
 		job.Mu.Unlock()
 
-		// Generate/update master playlist with all completed qualities
-		if err := generateAndUploadMasterPlaylist(job.Ctx, s3c, tempDir, req.MediaFileID, completedQualities); err != nil {
-			log.Printf("[CONVERTER] Job %s: Erro ao gerar master playlist: %v", job.ID, err)
+		// Generate/update master playlist with all completed qualities (e,
+		// no modo repair, as já existentes). No modo versioned a master só é
+		// trocada ao final, em publishVersion.
+		if !versioned {
+			for q := range existing {
+				if !containsString(completedQualities, q) {
+					completedQualities = append(completedQualities, q)
+				}
+			}
+			playlistStart := time.Now()
+			if err := generateAndUploadMasterPlaylist(ctx, output, tempDir, layout, completedQualities); err != nil {
+				qlogger.Error("Erro ao gerar master playlist", "phase", PhasePlaylist, "error", err.Error())
+			} else {
+				job.markPublished()
+			}
+			job.recordPhase(quality, PhasePlaylist, time.Since(playlistStart))
 		}
 
-		qualityS3Path := fmt.Sprintf("hls/%d/%s/master.m3u8", req.MediaFileID, quality)
-		log.Printf("[CONVERTER] Job %s: Conversão %s concluída. S3: %s", job.ID, quality, qualityS3Path)
+		qualityS3Path := layout.RenditionPlaylist(quality)
+		job.recordOutcome(quality, QualityCompleted, qualityS3Path, nil)
+		qlogger.Info("Conversão concluída", "phase", PhaseEncode, seconds(time.Since(qualityStart)), "s3_path", qualityS3Path)
 
-		sendCallback(getCallbackURL(), CallbackPayload{
+		sendQualityCallback(ctx, callback, job, CallbackPayload{
 			MediaID: req.MediaFileID,
 			Quality: quality,
 			Status:  "completed",
 			S3Path:  qualityS3Path,
-		})
+		}, false)
 
 		// Clean up quality temp files
 		qualityDir := filepath.Join(tempDir, quality)
 		os.RemoveAll(qualityDir)
-		log.Printf("[CONVERTER] Job %s: Arquivos temporários de %s limpos", job.ID, quality)
+		qlogger.Debug("Arquivos temporários da qualidade limpos", "phase", PhaseCleanup)
 	}
 
-	log.Printf("[CONVERTER] Job %s: Todas as qualidades processadas", job.ID)
+	logger.Info("Todas as qualidades processadas", seconds(time.Since(jobStart)))
+
+	if versioned {
+		job.Mu.Lock()
+		failed := len(job.FailedQualities)
+		completed := append([]string{}, job.CompletedQualities...)
+		job.Mu.Unlock()
+
+		if failed > 0 {
+			logger.Warn("Versão não publicada: há qualidades com falha", "phase", PhasePlaylist, "failed", failed)
+			return
+		}
+		// Mesmo com erro a master pode já apontar para a versão; não a remove.
+		published = true
+		publishStart := time.Now()
+		publishCtx := ctx
+		if job.Parked.Load() {
+			// Pausa com release depois da última qualidade: o job termina
+			// aqui e a versão precisa ser publicada mesmo com o ctx cancelado.
+			publishCtx = context.WithoutCancel(ctx)
+		}
+		if err := publishVersion(publishCtx, output, tempDir, layout, job, completed); err != nil {
+			logger.Error("Erro ao publicar versão", "phase", PhasePlaylist, "error", err.Error())
+		} else {
+			job.markPublished()
+		}
+		job.recordPhase("", PhasePlaylist, time.Since(publishStart))
+	}
+}
+
+func convertFromSource(ctx context.Context, job *ConversionJob, source *sourceInput, output Storage, watermarkPath string, tempDir string, quality string) error {
+	inputPath, err := source.Path(ctx)
+	if err != nil {
+		return err
+	}
+	return convertQuality(ctx, job, output, inputPath, watermarkPath, tempDir, quality)
 }
 
-func convertQuality(job *ConversionJob, s3c *S3Client, originalPath string, watermarkPath string, tempDir string, quality string) error {
+func convertQuality(ctx context.Context, job *ConversionJob, output Storage, originalPath string, watermarkPath string, tempDir string, quality string) (err error) {
+	ctx, span := tracer.Start(ctx, "convertQuality", trace.WithAttributes(
+		append(jobAttributes(job), attribute.String("hls.quality", quality))...,
+	))
+	defer func() { endSpan(span, err) }()
+	ctx = withLogger(ctx, loggerFromContext(ctx).With("quality", quality))
+	logger := ctxLogger(ctx, "ffmpeg")
+
 	settings, ok := QualityMap[quality]
 	if !ok {
-		return fmt.Errorf("qualidade desconhecida: %s", quality)
+		return permanent(fmt.Errorf("qualidade desconhecida: %s", quality))
 	}
 
 	qualityDir := filepath.Join(tempDir, quality)
-	if err := os.MkdirAll(qualityDir, 0755); err != nil {
-		return fmt.Errorf("erro ao criar diretório de qualidade: %w", err)
-	}
-
 	outputPlaylist := filepath.Join(qualityDir, "master.m3u8")
 	segmentPattern := filepath.Join(qualityDir, "segment_%03d.ts")
 
@@ -200,9 +373,7 @@ func convertQuality(job *ConversionJob, s3c *S3Client, originalPath string, wate
 	}
 
 	// ✅ Monta args do ffmpeg
-	args := []string{
-		"-i", originalPath,
-	}
+	args := inputArgs(originalPath)
 
 	// Adiciona watermark se existir
 	if watermarkPath != "" && watermarkFilter != "" {
@@ -264,32 +435,94 @@ func convertQuality(job *ConversionJob, s3c *S3Client, originalPath string, wate
 		outputPlaylist,
 	)
 
-	log.Printf("[FFMPEG] Executando: %s %s", getFFmpegPath(), strings.Join(args, " "))
+	// Com assinante de quality.progress, o ffmpeg reporta o avanço no stdout.
+	target := callbackFor(job.Request)
+	progress := !target.Legacy && target.Wants(EventQualityProgress)
+	if progress {
+		args = append([]string{"-progress", "pipe:1"}, args...)
+	}
+
+	logger.Debug("Executando ffmpeg", "phase", PhaseEncode, "command", getFFmpegPath()+" "+redactArgs(args))
 	start := time.Now()
+	pausedBefore := job.pausedDuration()
 
-	cmd := exec.CommandContext(job.Ctx, getFFmpegPath(), args...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	err = retryPhase(ctx, PhaseEncode, func() error {
+		// Segmentos de uma tentativa anterior não podem ir para o upload.
+		if err := os.RemoveAll(qualityDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(qualityDir, 0755); err != nil {
+			return fmt.Errorf("erro ao criar diretório de qualidade: %w", err)
+		}
 
-	if err := cmd.Run(); err != nil {
-		if job.Ctx.Err() != nil {
-			return fmt.Errorf("conversão cancelada")
+		cmd := exec.CommandContext(ctx, getFFmpegPath(), args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if progress {
+			cmd.Stdout = newProgressWriter(ctx, job, quality)
+		}
+		// Não espera indefinidamente pelo stderr se o processo for morto no cancelamento.
+		cmd.WaitDelay = 5 * time.Second
+
+		err := cmd.Start()
+		if err == nil {
+			job.attachProcess(cmd.Process)
+			err = cmd.Wait()
+			job.attachProcess(nil)
+		}
+		if cmd.ProcessState != nil {
+			observeFFmpegExit(cmd.ProcessState.ExitCode())
 		}
-		return fmt.Errorf("ffmpeg falhou: %v - %s", err, stderr.String())
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("conversão cancelada")
+			}
+			signaled := cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == -1
+			return &ffmpegError{err: err, stderr: truncateStderr(stderr.String()), signaled: signaled}
+		}
+		return nil
+	})
+	// O tempo com o ffmpeg suspenso vai para a fase paused.
+	elapsed := time.Since(start) - (job.pausedDuration() - pausedBefore)
+	job.recordPhase(quality, PhaseEncode, elapsed)
+	if err != nil {
+		return err
 	}
 
-	elapsed := time.Since(start)
-	log.Printf("[FFMPEG] Conversão %s concluída em %s", quality, elapsed)
+	logger.Info("ffmpeg concluído", "phase", PhaseEncode, seconds(elapsed))
+	observeEncode(quality, elapsed.Seconds(), job.Request.Duration)
+
+	if segments, size, duration, err := renditionStats(qualityDir, outputPlaylist); err != nil {
+		logger.Warn("Erro ao medir rendition", "phase", PhaseEncode, "error", err.Error())
+	} else {
+		job.recordRendition(quality, segments, size, duration)
+	}
 
 	// Upload HLS files to S3
-	s3Prefix := fmt.Sprintf("hls/%d/%s", job.Request.MediaFileID, quality)
-	if err := s3c.UploadDirectory(job.Ctx, qualityDir, s3Prefix); err != nil {
+	s3Prefix := outputLayout(job).RenditionDir(quality)
+	uploadStart := time.Now()
+	err = output.UploadDirectory(ctx, qualityDir, s3Prefix)
+	job.recordPhase(quality, PhaseUpload, time.Since(uploadStart))
+	if err != nil {
 		return fmt.Errorf("erro ao enviar para S3: %w", err)
 	}
+	logger.Info("Renditions enviadas", "phase", PhaseUpload, "prefix", s3Prefix, seconds(time.Since(uploadStart)))
 
 	return nil
 }
 
+// redactArgs formata os argumentos do ffmpeg para log sem expor assinaturas de URLs.
+func redactArgs(args []string) string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		if strings.HasPrefix(a, "http://") || strings.HasPrefix(a, "https://") {
+			a = redactURL(a)
+		}
+		out[i] = a
+	}
+	return strings.Join(out, " ")
+}
+
 // getPositionFilter retorna o filtro de posição para overlay
 func getPositionFilter(position string, size int) string {
 	// size é porcentagem da largura do vídeo
@@ -312,21 +545,35 @@ func getPositionFilter(position string, size int) string {
 	}
 }
 
-func generateAndUploadMasterPlaylist(ctx context.Context, s3c *S3Client, tempDir string, mediaFileID int, completedQualities []string) error {
+func generateAndUploadMasterPlaylist(ctx context.Context, output Storage, tempDir string, layout OutputLayout, completedQualities []string) error {
+	renditions := make(map[string]string, len(completedQualities))
+	for _, q := range completedQualities {
+		renditions[q] = layout.RenditionPlaylist(q)
+	}
+	return uploadMasterPlaylist(ctx, output, tempDir, layout.MasterPlaylist(), renditions)
+}
+
+// uploadMasterPlaylist grava em masterKey uma master playlist que referencia
+// as playlists das qualidades (qualidade -> chave) por caminho relativo.
+func uploadMasterPlaylist(ctx context.Context, output Storage, tempDir string, masterKey string, renditions map[string]string) error {
+	qualities := make([]string, 0, len(renditions))
+	for q := range renditions {
+		qualities = append(qualities, q)
+	}
 	// Sort qualities by bandwidth for consistent ordering
-	sort.Slice(completedQualities, func(i, j int) bool {
-		return QualityBandwidth[completedQualities[i]] < QualityBandwidth[completedQualities[j]]
+	sort.Slice(qualities, func(i, j int) bool {
+		return QualityBandwidth[qualities[i]] < QualityBandwidth[qualities[j]]
 	})
 
 	var builder strings.Builder
 	builder.WriteString("#EXTM3U\n")
 	builder.WriteString("#EXT-X-VERSION:3\n")
 
-	for _, q := range completedQualities {
+	for _, q := range qualities {
 		bandwidth := QualityBandwidth[q]
 		resolution := QualityResolution[q]
 		builder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%s\n", bandwidth, resolution))
-		builder.WriteString(fmt.Sprintf("%s/master.m3u8\n", q))
+		builder.WriteString(relativePath(path.Dir(masterKey), renditions[q]) + "\n")
 	}
 
 	masterPath := filepath.Join(tempDir, "master.m3u8")
@@ -334,35 +581,62 @@ func generateAndUploadMasterPlaylist(ctx context.Context, s3c *S3Client, tempDir
 		return fmt.Errorf("erro ao escrever master playlist: %w", err)
 	}
 
-	s3Key := fmt.Sprintf("hls/%d/master.m3u8", mediaFileID)
-	return s3c.Upload(ctx, masterPath, s3Key)
+	return output.Upload(ctx, masterPath, masterKey)
 }
 
-func sendCallback(callbackURL string, payload CallbackPayload) {
+// postCallback serializa, assina e envia o payload, registrando o resultado
+// no span e nas métricas. Com segredo configurado, o corpo é assinado com
+// HMAC-SHA256 sobre "timestamp.corpo": X-HLS-Timestamp traz o timestamp e
+// X-HLS-Signature o "sha256=<hex>".
+func postCallback(ctx context.Context, span trace.Span, logger *slog.Logger, target CallbackTarget, status string, payload any) {
+	callbackURL := target.URL
+
 	body, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[CALLBACK] Erro ao serializar payload: %v", err)
+		logger.Error("Erro ao serializar payload", "error", err.Error())
+		span.RecordError(err)
 		return
 	}
 
-	log.Printf("[CALLBACK] Enviando para %s: %s", callbackURL, string(body))
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	logger.Info("Enviando callback", "callback_url", callbackURL, "payload", string(body))
+	start := time.Now()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
 	if err != nil {
-		log.Printf("[CALLBACK] Erro ao criar request: %v", err)
+		logger.Error("Erro ao criar request", "error", err.Error())
+		span.RecordError(err)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if target.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-HLS-Timestamp", ts)
+		req.Header.Set("X-HLS-Signature", signCallback(target.Secret, ts, body))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("[CALLBACK] Erro ao enviar callback: %v", err)
+		logger.Error("Erro ao enviar callback", seconds(time.Since(start)), "error", err.Error())
+		observeCallback(status, false)
+		endSpan(span, err)
 		return
 	}
 	defer resp.Body.Close()
 
-	log.Printf("[CALLBACK] Resposta: %d", resp.StatusCode)
+	observeCallback(status, resp.StatusCode < 300)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 300 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	logger.Info("Callback respondido", "http_status", resp.StatusCode, seconds(time.Since(start)))
+}
+
+func signCallback(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EventSchemaVersion é a versão do envelope Event. Campos novos podem entrar
+// sem mudar a versão; remoções ou mudanças de significado a incrementam.
+const EventSchemaVersion = 1
+
+const (
+	EventJobQueued    = "job.queued"
+	EventJobStarted   = "job.started"
+	EventJobPaused    = "job.paused"
+	EventJobResumed   = "job.resumed"
+	EventJobRequeued  = "job.requeued"
+	EventJobCompleted = "job.completed"
+	EventJobPartial   = "job.partial"
+	EventJobFailed    = "job.failed"
+	EventJobCancelled = "job.cancelled"
+
+	EventQualityStarted   = "quality.started"
+	EventQualityProgress  = "quality.progress"
+	EventQualityCompleted = "quality.completed"
+	EventQualityFailed    = "quality.failed"
+
+	EventBatchCompleted = "batch.completed"
+)
+
+var eventTypes = []string{
+	EventJobQueued, EventJobStarted, EventJobPaused, EventJobResumed, EventJobRequeued,
+	EventJobCompleted, EventJobPartial, EventJobFailed, EventJobCancelled,
+	EventQualityStarted, EventQualityProgress, EventQualityCompleted, EventQualityFailed,
+	EventBatchCompleted,
+}
+
+// Event é o envelope enviado aos callbacks. Data depende de Type:
+//   - job.queued: JobQueuedData
+//   - job.started e job.requeued: JobQualitiesData
+//   - job.paused: JobPausedData; job.resumed não tem data
+//   - job.completed, job.partial, job.failed e job.cancelled: JobSummary
+//   - quality.started, quality.completed e quality.failed: QualityEventData
+//   - quality.progress: QualityProgressData
+//   - batch.completed: BatchStatus
+//
+// Sequence cresce na ordem em que os eventos são criados. Os envios são
+// concorrentes (job.queued sai em paralelo ao worker, quality.progress em
+// paralelo ao ffmpeg), então um evento pode chegar depois de outro mais novo:
+// o assinante descarta os de Sequence menor que o último já aplicado.
+type Event struct {
+	Version      int       `json:"version"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Sequence     uint64    `json:"sequence"`
+	Timestamp    time.Time `json:"timestamp"`
+	ConversionID string    `json:"conversion_id,omitempty"`
+	MediaID      int       `json:"media_id,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	BatchID      string    `json:"batch_id,omitempty"`
+	Data         any       `json:"data,omitempty"`
+}
+
+type JobQueuedData struct {
+	Queue     string   `json:"queue,omitempty"`
+	Priority  int      `json:"priority"`
+	Qualities []string `json:"qualities"`
+}
+
+// JobQualitiesData lista as qualidades que o job vai processar (job.started)
+// ou que precisam ser reenviadas (job.requeued).
+type JobQualitiesData struct {
+	Qualities []string `json:"qualities"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+const (
+	PauseHeld      = "held"
+	PauseSuspended = "suspended"
+	PauseReleased  = "released"
+)
+
+// JobPausedData indica como o job foi pausado: retido na fila, com o ffmpeg
+// suspenso ou com o worker liberado.
+type JobPausedData struct {
+	Mode string `json:"mode"`
+}
+
+type QualityEventData struct {
+	Quality   string `json:"quality"`
+	Path      string `json:"path,omitempty"`
+	Existing  bool   `json:"existing,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// QualityProgressData é o avanço do ffmpeg na qualidade. Percent só existe
+// quando o request traz a duration da mídia.
+type QualityProgressData struct {
+	Quality string  `json:"quality"`
+	Seconds float64 `json:"seconds"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+// lastEventSequence é o último Event.Sequence emitido.
+var lastEventSequence atomic.Uint64
+
+// nextEventSequence retorna um valor maior que todos os anteriores. A base é o
+// relógio em microssegundos, para que a sequência continue crescendo entre
+// restarts e nos retries, que mantêm o conversion_id.
+func nextEventSequence() uint64 {
+	for {
+		last := lastEventSequence.Load()
+		next := max(last+1, uint64(time.Now().UnixMicro()))
+		if lastEventSequence.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+func newEvent(typ, conversionID string, req ConvertRequest, data any) Event {
+	return Event{
+		Version:      EventSchemaVersion,
+		ID:           uuid.New().String(),
+		Type:         typ,
+		Sequence:     nextEventSequence(),
+		Timestamp:    time.Now().UTC(),
+		ConversionID: conversionID,
+		MediaID:      req.MediaFileID,
+		Tenant:       req.Tenant,
+		BatchID:      req.BatchID,
+		Data:         data,
+	}
+}
+
+// getCallbackEvents lê CALLBACK_EVENTS: tipos de evento separados por
+// vírgula, aceitando "job.*" e "quality.*". Vazio recebe todos.
+func getCallbackEvents() []string {
+	var events []string
+	for _, e := range strings.Split(os.Getenv("CALLBACK_EVENTS"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// getCallbackLegacy indica se os callbacks usam o formato anterior ao
+// envelope (CALLBACK_LEGACY).
+func getCallbackLegacy() bool {
+	return getEnvBool("CALLBACK_LEGACY", false)
+}
+
+func getCallbackProgressInterval() time.Duration {
+	if n := getEnvInt("CALLBACK_PROGRESS_INTERVAL_SECONDS", 10); n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// validateEventFilter recusa filtros com tipos de evento desconhecidos.
+func validateEventFilter(events []string) error {
+	for _, e := range events {
+		if e == "*" || e == "job.*" || e == "quality.*" || e == "batch.*" || containsString(eventTypes, e) {
+			continue
+		}
+		return fmt.Errorf("evento de callback desconhecido: %s", e)
+	}
+	return nil
+}
+
+// Wants indica se o assinante recebe o tipo de evento.
+func (t CallbackTarget) Wants(typ string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == "*" || e == typ {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(e, "*"); ok && strings.HasPrefix(typ, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendEvent envia o evento ao assinante, se ele o recebe. No formato legado
+// é enviado o payload antigo equivalente; eventos sem equivalente (legacy
+// nil) não são enviados.
+func sendEvent(ctx context.Context, target CallbackTarget, ev Event, legacy any) {
+	if !target.Wants(ev.Type) {
+		return
+	}
+	var payload any = ev
+	if target.Legacy {
+		if legacy == nil {
+			return
+		}
+		payload = legacy
+	}
+
+	// O callback precisa sair mesmo se o job foi cancelado, mas mantém o trace.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	ctx, span := tracer.Start(ctx, "sendCallback", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int("hls.media_file_id", ev.MediaID),
+		attribute.String("hls.event_type", ev.Type),
+		attribute.String("hls.event_id", ev.ID),
+	))
+	defer span.End()
+
+	logger := ctxLogger(ctx, "callback").With("phase", PhaseCallback, "event", ev.Type, "event_id", ev.ID)
+	postCallback(ctx, span, logger, target, ev.Type, payload)
+}
+
+// sendJobEvent envia, fora do worker, um evento do ciclo de vida do job
+// (enfileirado, pausado, retomado) sem segurar quem o emitiu.
+func sendJobEvent(job *ConversionJob, typ string, data any) {
+	ev := newEvent(typ, job.ID, job.Request, data)
+	ctx := withLogger(context.WithoutCancel(job.Ctx), jobLogger(job))
+	go sendEvent(ctx, callbackFor(job.Request), ev, nil)
+}
+
+// sendQualityCallback reporta o resultado de uma qualidade: quality.completed
+// ou quality.failed, ou o CallbackPayload no formato legado.
+func sendQualityCallback(ctx context.Context, target CallbackTarget, job *ConversionJob, p CallbackPayload, existing bool) {
+	typ := EventQualityCompleted
+	if p.Status == "failed" {
+		typ = EventQualityFailed
+	}
+	data := QualityEventData{Quality: p.Quality, Path: p.S3Path, Existing: existing, Error: p.ErrorMessage, ErrorKind: p.ErrorKind}
+	sendEvent(ctx, target, newEvent(typ, job.ID, job.Request, data), p)
+}
+
+// sendRequeuedCallback avisa que as qualidades precisam ser reenviadas: um
+// job.requeued, ou um CallbackPayload "requeued" por qualidade no formato
+// legado.
+func sendRequeuedCallback(ctx context.Context, conversionID string, req ConvertRequest, qualities []string) {
+	const reason = "serviço encerrado antes da conversão; reenviar"
+	target := callbackFor(req)
+	ev := newEvent(EventJobRequeued, conversionID, req, JobQualitiesData{Qualities: qualities, Reason: reason})
+	if !target.Legacy {
+		sendEvent(ctx, target, ev, nil)
+		return
+	}
+	for _, quality := range qualities {
+		sendEvent(ctx, target, ev, CallbackPayload{
+			MediaID:      req.MediaFileID,
+			Quality:      quality,
+			Status:       "requeued",
+			ErrorMessage: reason,
+		})
+	}
+}
+
+// progressWriter lê a saída de "-progress pipe:1" do ffmpeg e envia
+// quality.progress no máximo a cada CALLBACK_PROGRESS_INTERVAL_SECONDS. Um
+// envio lento não segura o ffmpeg: o próximo é descartado até ele terminar.
+type progressWriter struct {
+	ctx      context.Context
+	target   CallbackTarget
+	job      *ConversionJob
+	quality  string
+	interval time.Duration
+	last     time.Time
+	sending  atomic.Bool
+	buf      []byte
+}
+
+func newProgressWriter(ctx context.Context, job *ConversionJob, quality string) *progressWriter {
+	return &progressWriter{
+		ctx:      ctx,
+		target:   callbackFor(job.Request),
+		job:      job,
+		quality:  quality,
+		interval: getCallbackProgressInterval(),
+		last:     time.Now(),
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+
+		// out_time_us e out_time_ms trazem ambos microssegundos.
+		v, ok := strings.CutPrefix(line, "out_time_us=")
+		if !ok {
+			continue
+		}
+		us, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || time.Since(w.last) < w.interval {
+			continue
+		}
+		w.last = time.Now()
+		w.report(float64(us) / 1e6)
+	}
+}
+
+func (w *progressWriter) report(secs float64) {
+	if !w.sending.CompareAndSwap(false, true) {
+		return
+	}
+	data := QualityProgressData{Quality: w.quality, Seconds: secs}
+	if d := w.job.Request.Duration; d > 0 {
+		data.Percent = min(100, float64(int(secs/float64(d)*1000))/10)
+	}
+	ev := newEvent(EventQualityProgress, w.job.ID, w.job.Request, data)
+	go func() {
+		defer w.sending.Store(false)
+		sendEvent(w.ctx, w.target, ev, nil)
+	}()
+}
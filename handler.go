@@ -3,19 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Handler struct {
-	queue *JobQueue
+	queue       *JobQueue
+	idempotency *IdempotencyStore
 }
 
 func NewHandler(queue *JobQueue) *Handler {
-	return &Handler{queue: queue}
+	return &Handler{queue: queue, idempotency: NewIdempotencyStore()}
 }
 
 func (h *Handler) HandleConvert(w http.ResponseWriter, r *http.Request) {
@@ -24,35 +35,325 @@ func (h *Handler) HandleConvert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Continua o trace iniciado pelo Laravel (header traceparent), se houver.
+	reqCtx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	reqCtx, span := tracer.Start(reqCtx, "HandleConvert", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	var req ConvertRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "JSON inválido: " + err.Error()})
 		return
 	}
 
-	if req.MediaFileID == 0 || req.S3Path == "" || len(req.Qualities) == 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Campos obrigatórios: media_file_id, s3_path, qualities"})
+	// Credenciais de um tenant só criam jobs para ele; credenciais globais
+	// podem informar o tenant no corpo.
+	principalFromContext(r.Context()).scopeRequest(&req)
+	req.BatchID = ""
+
+	if err := h.validateRequest(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
+	attached := false
+	submit := func() (string, error) {
+		id, err := h.submit(reqCtx, req)
+		var dup *DuplicateJobError
+		if errors.As(err, &dup) && dup.Policy == ConflictAttach {
+			attached = true
+			return dup.ConversionID, nil
+		}
+		return id, err
+	}
+
+	var conversionID string
+	var replayed bool
+	var err error
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		conversionID, replayed, err = h.idempotency.Do(req.Tenant+"\x00"+key, requestFingerprint(req), submit)
+	} else {
+		conversionID, err = submit()
+	}
+
+	if errors.Is(err, ErrIdempotencyMismatch) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeEnqueueError(w, err)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("hls.conversion_id", conversionID),
+		attribute.Int("hls.media_file_id", req.MediaFileID),
+		attribute.Bool("hls.idempotent_replay", replayed),
+		attribute.Bool("hls.attached", attached),
+	)
+
+	switch {
+	case replayed:
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeJSON(w, http.StatusOK, ConvertResponse{
+			ConversionID: conversionID,
+			Message:      "Requisição repetida, conversão já criada",
+		})
+	case attached:
+		writeJSON(w, http.StatusOK, ConvertResponse{
+			ConversionID: conversionID,
+			Message:      "Conversão já ativa para esta mídia",
+		})
+	default:
+		writeJSON(w, http.StatusAccepted, ConvertResponse{
+			ConversionID: conversionID,
+			Message:      "Conversão iniciada",
+		})
+	}
+}
+
+// validateRequest confere os campos do request antes de reservar cota e
+// enfileirar; a mensagem do erro vai para o cliente.
+func (h *Handler) validateRequest(req ConvertRequest) error {
+	if req.MediaFileID == 0 || (req.S3Path == "" && req.SourceURL == "") || len(req.Qualities) == 0 {
+		return errors.New("Campos obrigatórios: media_file_id, s3_path ou source_url, qualities")
+	}
+
+	if !validInputMode(req.InputMode) {
+		return errors.New("input_mode deve ser download, stream ou auto")
+	}
+
+	if !validConvertMode(req.Mode) {
+		return errors.New("mode deve ser full ou repair")
+	}
+
+	if req.SourceURL != "" {
+		if req.InputMode == InputModeStream {
+			return errors.New("input_mode stream não é suportado com source_url; o original é baixado")
+		}
+		if err := NewHTTPSource().Validate(req.SourceURL); err != nil {
+			return err
+		}
+	}
+
+	if !validConflictPolicy(req.OnConflict) {
+		return errors.New("on_conflict deve ser reject, supersede ou attach")
+	}
+
+	if err := tenants.Validate(req.Tenant); err != nil {
+		return fmt.Errorf("%w: %s", err, req.Tenant)
+	}
+
+	if t := tenants.Lookup(req.Tenant); t != nil && t.MonthlyMinutes > 0 && req.Duration <= 0 {
+		return errors.New("duration é obrigatório para tenants com cota de minutos")
+	}
+
+	if err := validateLayout(req); err != nil {
+		return err
+	}
+
+	if _, err := h.queue.ResolveQueue(req.Queue); err != nil {
+		return errors.New("queue deve ser uma de: " + strings.Join(h.queue.QueueNames(), ", "))
+	}
+	return nil
+}
+
+// submit debita a cota do tenant, cria o job e o enfileira, retornando o
+// novo conversion_id. Se o job não for aceito, os minutos são devolvidos.
+func (h *Handler) submit(reqCtx context.Context, req ConvertRequest) (string, error) {
+	if err := tenants.ReserveMinutes(req); err != nil {
+		return "", err
+	}
+
 	conversionID := uuid.New().String()
-	ctx, cancel := context.WithCancel(context.Background())
 
-	job := &ConversionJob{
-		ID:      conversionID,
-		Request: req,
-		Cancel:  cancel,
-		Ctx:     ctx,
+	// O job vive além da requisição HTTP: herda apenas o span, não o cancelamento.
+	job := newConversionJob(context.WithoutCancel(reqCtx), conversionID, req)
+
+	if err := h.queue.Enqueue(job); err != nil {
+		job.Cancel()
+		tenants.ReleaseMinutes(req)
+		return "", err
 	}
 
-	h.queue.Enqueue(job)
+	slog.Info("Conversão criada", "component", "handler", "conversion_id", conversionID, "media_file_id", req.MediaFileID,
+		"tenant", req.Tenant, "principal", principalFromContext(reqCtx).Subject)
+	return conversionID, nil
+}
 
-	log.Printf("[HANDLER] Conversão %s criada para media_file_id=%d", conversionID, req.MediaFileID)
+// HandleBatch recebe um lote de conversões: POST /api/hls/batch com um array
+// JSON de ConvertRequest ou NDJSON (um request por linha). Cada item é
+// validado e enfileirado; os recusados voltam com o erro. Com ?atomic=true,
+// qualquer recusa recusa o lote inteiro e nada é enfileirado.
+func (h *Handler) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
 
-	writeJSON(w, http.StatusAccepted, ConvertResponse{
-		ConversionID: conversionID,
-		Message:      "Conversão iniciada",
-	})
+	reqCtx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	reqCtx, span := tracer.Start(reqCtx, "HandleBatch", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	atomic, err := strconv.ParseBool(r.URL.Query().Get("atomic"))
+	if err != nil && r.URL.Query().Has("atomic") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "atomic deve ser true ou false"})
+		return
+	}
+
+	maxBytes := getBatchMaxBodyBytes()
+	reqs, err := decodeBatch(http.MaxBytesReader(w, r.Body, maxBytes), getBatchMaxItems())
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Lote excede %d bytes", maxBytes)})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "JSON inválido: " + err.Error()})
+		return
+	}
+	if len(reqs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Lote vazio"})
+		return
+	}
+
+	principal := principalFromContext(r.Context())
+	batch := &Batch{ID: uuid.New().String(), Tenant: principal.Tenant, CreatedAt: time.Now(), Items: make([]BatchItem, len(reqs))}
+
+	// Validação: um item inválido não impede os demais, exceto com atomic.
+	seen := make(map[string]int)
+	for i := range reqs {
+		req := &reqs[i]
+		principal.scopeRequest(req)
+		req.BatchID = batch.ID
+		item := &batch.Items[i]
+		item.Index, item.MediaFileID = i, req.MediaFileID
+
+		if err := h.validateRequest(*req); err != nil {
+			item.Error = err.Error()
+			continue
+		}
+		key := req.Tenant + "\x00" + strconv.Itoa(req.MediaFileID)
+		if first, ok := seen[key]; ok {
+			item.Error = fmt.Sprintf("media_file_id repetido no lote (item %d)", first)
+			continue
+		}
+		seen[key] = i
+	}
+	if batch.Tenant == "" {
+		batch.Tenant = commonTenant(reqs)
+	}
+	if atomic && batch.Rejected() > 0 {
+		writeBatchResponse(w, http.StatusUnprocessableEntity, batch, "Lote recusado: há itens inválidos")
+		return
+	}
+
+	var jobs []*ConversionJob
+	var indexes []int
+	for i, req := range reqs {
+		item := &batch.Items[i]
+		if item.Error != "" {
+			continue
+		}
+		if err := tenants.ReserveMinutes(req); err != nil {
+			item.Error = err.Error()
+			if atomic {
+				break
+			}
+			continue
+		}
+		// Os jobs vivem além da requisição HTTP: herdam apenas o span.
+		jobs = append(jobs, newConversionJob(context.WithoutCancel(reqCtx), uuid.New().String(), req))
+		indexes = append(indexes, i)
+	}
+
+	var errs []error
+	if !atomic || batch.Rejected() == 0 {
+		errs = h.queue.EnqueueBatch(jobs, atomic)
+	}
+	refused := atomic && (batch.Rejected() > 0 || slices.ContainsFunc(errs, func(err error) bool { return err != nil }))
+	for k, job := range jobs {
+		item := &batch.Items[indexes[k]]
+		if errs != nil && errs[k] != nil {
+			item.Error = errs[k].Error()
+		}
+		if refused || item.Error != "" {
+			job.Cancel()
+			tenants.ReleaseMinutes(job.Request)
+			continue
+		}
+		item.ConversionID = job.ID
+	}
+
+	span.SetAttributes(
+		attribute.String("hls.batch_id", batch.ID),
+		attribute.Int("hls.batch_items", len(batch.Items)),
+		attribute.Int("hls.batch_rejected", batch.Rejected()),
+		attribute.Bool("hls.batch_atomic", atomic),
+	)
+
+	if refused {
+		status := http.StatusConflict
+		if slices.ContainsFunc(errs, func(err error) bool { return errors.Is(err, ErrQueueFull) }) {
+			w.Header().Set("Retry-After", strconv.Itoa(getEnvInt("QUEUE_RETRY_AFTER_SECONDS", 30)))
+			status = http.StatusTooManyRequests
+		}
+		writeBatchResponse(w, status, batch, "Lote recusado: nem todos os itens puderam ser enfileirados")
+		return
+	}
+	if batch.Rejected() == len(batch.Items) {
+		writeBatchResponse(w, http.StatusUnprocessableEntity, batch, "Nenhum item do lote foi aceito")
+		return
+	}
+
+	h.queue.batches.Add(batch)
+	slog.Info("Lote criado", "component", "handler", "batch_id", batch.ID, "tenant", batch.Tenant,
+		"accepted", len(batch.Items)-batch.Rejected(), "rejected", batch.Rejected(), "principal", principal.Subject)
+	// Jobs rápidos podem ter terminado antes de o lote ser registrado.
+	go h.queue.completeBatch(batch.ID)
+	writeBatchResponse(w, http.StatusAccepted, batch, "Lote iniciado")
+}
+
+// commonTenant retorna o tenant dos requests, se todos forem do mesmo.
+func commonTenant(reqs []ConvertRequest) string {
+	for _, req := range reqs[1:] {
+		if req.Tenant != reqs[0].Tenant {
+			return ""
+		}
+	}
+	return reqs[0].Tenant
+}
+
+func writeBatchResponse(w http.ResponseWriter, status int, batch *Batch, message string) {
+	resp := BatchResponse{
+		Accepted: len(batch.Items) - batch.Rejected(),
+		Rejected: batch.Rejected(),
+		Message:  message,
+		Items:    batch.Items,
+	}
+	if status == http.StatusAccepted {
+		resp.BatchID = batch.ID
+	} else {
+		resp.Accepted = 0
+	}
+	writeJSON(w, status, resp)
+}
+
+// HandleBatchStatus retorna o progresso agregado de um lote:
+// GET /api/hls/batch/{batch_id}
+func (h *Handler) HandleBatchStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batchID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/batch/"), "/")
+	st, ok := h.queue.BatchStatus(batchID)
+	if !ok || !principalFromContext(r.Context()).CanAccess(st.Tenant) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Lote não encontrado"})
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
 }
 
 func (h *Handler) HandleCancel(w http.ResponseWriter, r *http.Request) {
@@ -70,8 +371,16 @@ func (h *Handler) HandleCancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status, ok := h.queue.Status(conversionID); ok && !principalFromContext(r.Context()).CanAccess(status.Tenant) {
+		writeJSON(w, http.StatusNotFound, CancelResponse{
+			Success: false,
+			Message: "Conversão não encontrada",
+		})
+		return
+	}
+
 	if h.queue.Cancel(conversionID) {
-		log.Printf("[HANDLER] Conversão %s cancelada", conversionID)
+		slog.Info("Conversão cancelada", "component", "handler", "conversion_id", conversionID)
 		writeJSON(w, http.StatusOK, CancelResponse{
 			Success: true,
 			Message: "Conversão cancelada",
@@ -84,6 +393,351 @@ func (h *Handler) HandleCancel(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandleStatus retorna o estado de uma conversão ativa ou do histórico:
+// GET /api/hls/{conversion_id}
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/")
+	principal := principalFromContext(r.Context())
+	if status, ok := h.queue.Status(conversionID); ok && principal.CanAccess(status.Tenant) {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	// Encerrado: responde com o registro do histórico.
+	if rec, ok := h.queue.Record(conversionID); ok && principal.CanAccess(rec.Tenant) {
+		writeJSON(w, http.StatusOK, rec)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+}
+
+var jobStates = map[string]bool{
+	JobStateQueued: true, JobStateRunning: true, JobStateCancelled: true, JobStateCompleted: true,
+	JobStatePartial: true, JobStateFailed: true, JobStateSuperseded: true, JobStatePaused: true,
+}
+
+// HandleListJobs lista os jobs ativos e o histórico:
+// GET /api/hls/jobs?status=failed,partial&media_file_id=4821&from=2024-01-01&to=2024-01-02&limit=50&offset=0
+func (h *Handler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := JobFilter{Tenant: query.Get("tenant"), Limit: 50}
+	badRequest := func(msg string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	if v := query.Get("status"); v != "" {
+		filter.States = make(map[string]bool)
+		for _, s := range splitList(v) {
+			if !jobStates[s] {
+				badRequest("status inválido: " + s)
+				return
+			}
+			filter.States[s] = true
+		}
+	}
+	if v := query.Get("media_file_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			badRequest("media_file_id inválido")
+			return
+		}
+		filter.MediaFileID = id
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from"), false); err != nil {
+		badRequest("from inválido: use RFC 3339 ou AAAA-MM-DD")
+		return
+	}
+	if filter.To, err = parseTimeParam(query.Get("to"), true); err != nil {
+		badRequest("to inválido: use RFC 3339 ou AAAA-MM-DD")
+		return
+	}
+
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > 500 {
+			badRequest("limit deve estar entre 1 e 500")
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			badRequest("offset inválido")
+			return
+		}
+	}
+
+	// Credenciais de um tenant só enxergam os próprios jobs.
+	if p := principalFromContext(r.Context()); p.Tenant != "" {
+		filter.Tenant = p.Tenant
+	}
+
+	writeJSON(w, http.StatusOK, h.queue.ListJobs(filter))
+}
+
+// parseTimeParam aceita RFC 3339 ou uma data (UTC). Como limite final, uma
+// data inclui o dia inteiro.
+func parseTimeParam(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// HandleSetPriority altera a prioridade de um job ainda na fila:
+// POST /api/hls/{conversion_id}/priority com {"priority": 10}
+func (h *Handler) HandleSetPriority(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/priority")
+
+	var req PriorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Priority == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Campo obrigatório: priority"})
+		return
+	}
+
+	if status, ok := h.queue.Status(conversionID); ok && !principalFromContext(r.Context()).CanAccess(status.Tenant) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	}
+
+	switch err := h.queue.SetPriority(conversionID, *req.Priority); {
+	case errors.Is(err, ErrJobNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	case errors.Is(err, ErrJobNotQueued):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Conversão já iniciada, prioridade não pode ser alterada"})
+		return
+	}
+
+	status, _ := h.queue.Status(conversionID)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// HandlePause pausa uma conversão na fila ou em processamento:
+// POST /api/hls/{conversion_id}/pause[?release=true]. Sem release (padrão de
+// PAUSE_RELEASE_WORKER), o ffmpeg é suspenso e o job continua ocupando o
+// worker; com release, o worker é liberado e a qualidade em andamento é
+// refeita no resume.
+func (h *Handler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/pause")
+
+	release := getPauseReleaseWorker()
+	if v := r.URL.Query().Get("release"); v != "" {
+		var err error
+		if release, err = strconv.ParseBool(v); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "release deve ser true ou false"})
+			return
+		}
+	}
+
+	if status, ok := h.queue.Status(conversionID); ok && !principalFromContext(r.Context()).CanAccess(status.Tenant) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	}
+
+	switch err := h.queue.Pause(conversionID, release); {
+	case errors.Is(err, ErrJobNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	case errors.Is(err, ErrJobPaused):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Conversão já pausada"})
+		return
+	}
+
+	slog.Info("Conversão pausada", "component", "handler", "conversion_id", conversionID, "release", release,
+		"principal", principalFromContext(r.Context()).Subject)
+	status, _ := h.queue.Status(conversionID)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// HandleResume retoma uma conversão pausada:
+// POST /api/hls/{conversion_id}/resume
+func (h *Handler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/resume")
+
+	if status, ok := h.queue.Status(conversionID); ok && !principalFromContext(r.Context()).CanAccess(status.Tenant) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	}
+
+	switch err := h.queue.Resume(conversionID); {
+	case errors.Is(err, ErrJobNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	case errors.Is(err, ErrJobNotPaused):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Conversão não está pausada"})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Erro ao retomar conversão: " + err.Error()})
+		return
+	}
+
+	slog.Info("Conversão retomada", "component", "handler", "conversion_id", conversionID,
+		"principal", principalFromContext(r.Context()).Subject)
+	status, _ := h.queue.Status(conversionID)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// HandleRetry reenfileira as qualidades com falha de uma conversão
+// encerrada: POST /api/hls/{conversion_id}/retry, com corpo opcional
+// {"qualities": ["1080p"]}.
+func (h *Handler) HandleRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conversionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/hls/"), "/retry")
+
+	// Corpo vazio (inclusive chunked, sem Content-Length) repete tudo o que falhou.
+	var req RetryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "JSON inválido"})
+		return
+	}
+
+	var tenant string
+	found := true
+	if status, ok := h.queue.Status(conversionID); ok {
+		tenant = status.Tenant
+	} else if prev, ok := h.queue.Record(conversionID); ok {
+		tenant = prev.Tenant
+	} else {
+		found = false
+	}
+	principal := principalFromContext(r.Context())
+	if !found || !principal.CanAccess(tenant) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	}
+
+	job, err := h.queue.Retry(context.WithoutCancel(r.Context()), conversionID, req.Qualities)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Conversão não encontrada"})
+		return
+	case errors.Is(err, ErrJobActive):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Conversão ainda ativa"})
+		return
+	case errors.Is(err, ErrNoRetry), errors.Is(err, ErrNoSourceURL):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		writeEnqueueError(w, err)
+		return
+	}
+
+	slog.Info("Conversão reenfileirada", "component", "handler", "conversion_id", conversionID, "media_file_id", job.Request.MediaFileID,
+		"tenant", job.Request.Tenant, "principal", principal.Subject, "qualities", job.Request.Qualities)
+	writeJSON(w, http.StatusAccepted, ConvertResponse{
+		ConversionID: conversionID,
+		Message:      "Conversão reenfileirada",
+	})
+}
+
+// HandleDeleteMedia remove as renditions publicadas de uma mídia:
+// DELETE /api/hls/media/{media_id} ou DELETE /api/hls/media/{media_id}/{quality}.
+// Mídias com layout personalizado informam rendition_template/master_template
+// na query, como no request de conversão.
+func (h *Handler) HandleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/hls/media/"), "/"), "/")
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	mediaFileID, err := strconv.Atoi(parts[0])
+	if err != nil || mediaFileID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "media_id inválido"})
+		return
+	}
+	var quality string
+	if len(parts) == 2 {
+		quality = parts[1]
+		if _, ok := QualityMap[quality]; !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Qualidade inválida: " + quality})
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	principal := principalFromContext(r.Context())
+	req := ConvertRequest{
+		MediaFileID:       mediaFileID,
+		Tenant:            query.Get("tenant"),
+		RenditionTemplate: query.Get("rendition_template"),
+		MasterTemplate:    query.Get("master_template"),
+	}
+	// Credenciais de tenant só removem no layout do tenant.
+	principal.scopeRequest(&req)
+	if err := tenants.Validate(req.Tenant); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := validateLayout(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if h.queue.HasActiveJob(req.Tenant, mediaFileID) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "A mídia tem uma conversão ativa; cancele-a antes de remover as renditions"})
+		return
+	}
+
+	result, err := deleteMediaOutputs(r.Context(), req, quality)
+	logger := slog.With("component", "handler", "media_file_id", mediaFileID, "tenant", req.Tenant, "principal", principal.Subject)
+	switch {
+	case errors.Is(err, ErrLayoutNotDeletable):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		logger.Error("Erro ao remover renditions", "quality", quality, "deleted_objects", result.DeletedObjects, "error", err.Error())
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "Erro ao remover renditions: " + err.Error()})
+		return
+	}
+
+	logger.Info("Renditions removidas", "quality", quality, "deleted_objects", result.DeletedObjects, "prefixes", result.Prefixes)
+	writeJSON(w, http.StatusOK, result)
+}
+
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
@@ -92,6 +746,58 @@ func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
 }
 
+// HandleReady verifica as dependências do serviço (ffmpeg, codecs, storage,
+// disco e fila). Responde 503 se alguma falhar, para que o ECS/ALB deixe de
+// rotear novas conversões para esta task.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := runReadinessChecks(r.Context(), h.queue)
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+		slog.Warn("Serviço não está pronto", "component", "handler", "checks", resp.Checks)
+	}
+	writeJSON(w, status, resp)
+}
+
+// writeEnqueueError traduz as recusas da fila em respostas que o Laravel
+// consegue tratar com backoff: 429/503 com Retry-After e 409 para duplicatas.
+func writeEnqueueError(w http.ResponseWriter, err error) {
+	retryAfter := strconv.Itoa(getEnvInt("QUEUE_RETRY_AFTER_SECONDS", 30))
+
+	var dup *DuplicateJobError
+	switch {
+	case errors.As(err, &dup):
+		metricSubmissionsRejected.WithLabelValues("duplicate").Inc()
+		writeJSON(w, http.StatusConflict, ConvertResponse{
+			ConversionID: dup.ConversionID,
+			Message:      "Já existe uma conversão ativa para esta mídia",
+		})
+	case errors.Is(err, ErrUnknownQueue):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrQuotaExceeded):
+		metricSubmissionsRejected.WithLabelValues("tenant_quota").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(secondsUntilNextMonth()))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrTenantConcurrency):
+		metricSubmissionsRejected.WithLabelValues("tenant_concurrency").Inc()
+		w.Header().Set("Retry-After", retryAfter)
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrQueueFull):
+		metricSubmissionsRejected.WithLabelValues("queue_full").Inc()
+		w.Header().Set("Retry-After", retryAfter)
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "Fila cheia, tente novamente mais tarde"})
+	default:
+		metricSubmissionsRejected.WithLabelValues("shutting_down").Inc()
+		w.Header().Set("Retry-After", retryAfter)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Serviço em manutenção, tente novamente"})
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestQueue cria a fila sem o worker: os jobs enfileirados ficam parados
+// até o teste retirá-los.
+func newTestQueue(t *testing.T) *JobQueue {
+	t.Helper()
+	t.Setenv("JOB_HISTORY_FILE", "")
+	t.Setenv("BATCH_STATE_FILE", "")
+	t.Setenv("QUEUE_STATE_FILE", "")
+	t.Setenv("CALLBACK_URL", "")
+	t.Setenv("QUEUES", "")
+	q := &JobQueue{
+		queues:  getQueueConfigs(),
+		byName:  make(map[string]*namedQueue),
+		maxSize: getQueueMaxSize(),
+		ready:   make(chan struct{}, 1),
+		active:  make(map[string]*ConversionJob),
+		history: NewJobHistory(),
+		batches: NewBatchStore(),
+		paused:  make(map[string]*ConversionJob),
+		stop:    make(chan struct{}),
+	}
+	for _, nq := range q.queues {
+		q.byName[nq.name] = nq
+	}
+	return q
+}
+
+func TestHandleRetryBody(t *testing.T) {
+	q := newTestQueue(t)
+	q.history.Add(&JobRecord{
+		ConversionID:       "conv-1",
+		MediaFileID:        1,
+		CompletedQualities: []string{"360p"},
+		Request:            ConvertRequest{MediaFileID: 1, Qualities: []string{"360p", "720p"}},
+	})
+	h := &Handler{queue: q}
+
+	retry := func(body string, contentLength int64) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/hls/conv-1/retry", strings.NewReader(body))
+		r.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		h.HandleRetry(w, r)
+		return w.Code
+	}
+
+	if code := retry(`{"qualities":`, -1); code != http.StatusBadRequest {
+		t.Errorf("JSON inválido: status = %d, want 400", code)
+	}
+	// Chunked sem corpo: Content-Length desconhecido (-1), mas é um retry de tudo.
+	if code := retry("", -1); code != http.StatusAccepted {
+		t.Fatalf("corpo chunked vazio: status = %d, want 202", code)
+	}
+	q.mu.RLock()
+	job := q.active["conv-1"]
+	q.mu.RUnlock()
+	if job == nil || strings.Join(job.Request.Qualities, ",") != "720p" {
+		t.Errorf("job reenfileirado = %+v", job)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Estados de jobs encerrados, além de JobStateCancelled.
+const (
+	JobStateCompleted  = "completed"
+	JobStatePartial    = "partial"
+	JobStateFailed     = "failed"
+	JobStateSuperseded = "superseded"
+)
+
+// Resultado de cada qualidade no histórico.
+const (
+	QualityCompleted = "completed"
+	QualityFailed    = "failed"
+	QualityExisting  = "existing"
+	QualityCancelled = "cancelled"
+	QualityPending   = "pending"
+
+	// QualityDiscarded é uma qualidade concluída de uma versão não publicada
+	// cujos arquivos foram removidos por CLEANUP_PARTIAL_UPLOADS.
+	QualityDiscarded = "discarded"
+)
+
+// QualityOutcome é o resultado de uma qualidade do job, com o tempo gasto em
+// cada fase (em segundos).
+type QualityOutcome struct {
+	Quality   string             `json:"quality"`
+	Status    string             `json:"status"`
+	S3Path    string             `json:"s3_path,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	ErrorKind string             `json:"error_kind,omitempty"`
+	Phases    map[string]float64 `json:"phases,omitempty"`
+
+	// Estatísticas da rendition codificada; veja renditionStats.
+	Segments int     `json:"segments,omitempty"`
+	Bytes    int64   `json:"bytes,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// JobRecord é o retrato de um job para o histórico e a listagem: o request,
+// os tempos por fase, o resultado de cada qualidade e os caminhos de saída.
+type JobRecord struct {
+	ConversionID       string             `json:"conversion_id"`
+	MediaFileID        int                `json:"media_file_id"`
+	Tenant             string             `json:"tenant,omitempty"`
+	State              string             `json:"state"`
+	Queue              string             `json:"queue"`
+	Priority           int                `json:"priority"`
+	EnqueuedAt         time.Time          `json:"enqueued_at"`
+	StartedAt          *time.Time         `json:"started_at,omitempty"`
+	FinishedAt         *time.Time         `json:"finished_at,omitempty"`
+	Phases             map[string]float64 `json:"phases,omitempty"`
+	Qualities          []QualityOutcome   `json:"qualities"`
+	CompletedQualities []string           `json:"completed_qualities"`
+	MasterPath         string             `json:"master_path"`
+	Published          bool               `json:"published"`
+	// Request é o request original com a query string e as credenciais do
+	// source_url removidas: URLs pré-assinadas não podem aparecer na listagem
+	// nem em JOB_HISTORY_FILE.
+	Request ConvertRequest `json:"request"`
+
+	// sourceURL é o source_url completo, só em memória, para o retry.
+	sourceURL string
+}
+
+// recordPhase soma d ao tempo da fase no job e, se quality for informada, na
+// qualidade.
+func (j *ConversionJob) recordPhase(quality, phase string, d time.Duration) {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	if j.PhaseSeconds == nil {
+		j.PhaseSeconds = make(map[string]float64)
+	}
+	j.PhaseSeconds[phase] += d.Seconds()
+	if quality != "" {
+		o := j.outcome(quality)
+		if o.Phases == nil {
+			o.Phases = make(map[string]float64)
+		}
+		o.Phases[phase] += d.Seconds()
+	}
+}
+
+// recordOutcome registra o resultado final de uma qualidade.
+func (j *ConversionJob) recordOutcome(quality, status, s3Path string, err error) {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	o := j.outcome(quality)
+	o.Status = status
+	o.S3Path = s3Path
+	if err != nil {
+		o.Error = err.Error()
+		o.ErrorKind = errorKind(err)
+	}
+}
+
+// discardRendition desfaz a conclusão de uma qualidade cujos arquivos foram
+// removidos, para que um retry a codifique de novo.
+func (j *ConversionJob) discardRendition(quality string) {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	j.CompletedQualities = removeString(j.CompletedQualities, quality)
+	o := j.outcome(quality)
+	o.Status = QualityDiscarded
+	o.S3Path = ""
+}
+
+// outcome deve ser chamado com j.Mu travado.
+func (j *ConversionJob) outcome(quality string) *QualityOutcome {
+	if j.Outcomes == nil {
+		j.Outcomes = make(map[string]*QualityOutcome)
+	}
+	o, ok := j.Outcomes[quality]
+	if !ok {
+		o = &QualityOutcome{Quality: quality, Status: QualityPending}
+		j.Outcomes[quality] = o
+	}
+	return o
+}
+
+// newJobRecord monta o retrato do job. state vazio deduz o estado final a
+// partir do resultado das qualidades.
+func newJobRecord(job *ConversionJob, state string) *JobRecord {
+	job.Mu.Lock()
+	defer job.Mu.Unlock()
+
+	rec := &JobRecord{
+		ConversionID:       job.ID,
+		MediaFileID:        job.Request.MediaFileID,
+		Tenant:             job.Request.Tenant,
+		Queue:              job.Request.Queue,
+		Priority:           job.Request.Priority,
+		EnqueuedAt:         job.EnqueuedAt,
+		CompletedQualities: append([]string{}, job.CompletedQualities...),
+		MasterPath:         outputLayout(job).MasterPlaylist(),
+		Published:          job.Published,
+		Request:            job.Request,
+		Qualities:          []QualityOutcome{},
+		sourceURL:          job.Request.SourceURL,
+	}
+	if rec.Request.SourceURL != "" {
+		rec.Request.SourceURL = redactURL(rec.Request.SourceURL)
+	}
+	if !job.StartedAt.IsZero() {
+		started := job.StartedAt
+		rec.StartedAt = &started
+		rec.Phases = map[string]float64{PhaseQueue: started.Sub(job.EnqueuedAt).Seconds()}
+	}
+	if !job.FinishedAt.IsZero() {
+		finished := job.FinishedAt
+		rec.FinishedAt = &finished
+	}
+	for phase, s := range job.PhaseSeconds {
+		if rec.Phases == nil {
+			rec.Phases = make(map[string]float64)
+		}
+		rec.Phases[phase] = s
+	}
+
+	// Um job pausado com release também tem o contexto cancelado, mas só
+	// chega ao histórico se não sobrou qualidade para retomar.
+	cancelled := job.Ctx.Err() != nil && !job.Parked.Load()
+	var completed, failed int
+	for _, q := range job.Request.Qualities {
+		o := QualityOutcome{Quality: q, Status: QualityPending}
+		if existing, ok := job.Outcomes[q]; ok {
+			o = *existing
+		}
+		if o.Status == QualityPending && cancelled {
+			o.Status = QualityCancelled
+		}
+		switch o.Status {
+		case QualityCompleted, QualityExisting:
+			completed++
+		case QualityFailed:
+			failed++
+		}
+		rec.Qualities = append(rec.Qualities, o)
+	}
+
+	rec.State = state
+	if rec.State == "" {
+		switch {
+		case job.Superseded.Load():
+			rec.State = JobStateSuperseded
+		case cancelled:
+			rec.State = JobStateCancelled
+		case failed == 0:
+			rec.State = JobStateCompleted
+		case completed > 0 || len(rec.CompletedQualities) > 0:
+			rec.State = JobStatePartial
+		default:
+			rec.State = JobStateFailed
+		}
+	}
+	return rec
+}
+
+func getHistoryMaxEntries() int {
+	return max(1, getEnvInt("JOB_HISTORY_MAX_ENTRIES", 5000))
+}
+
+func getHistoryRetention() time.Duration {
+	return time.Duration(getEnvInt("JOB_HISTORY_RETENTION_HOURS", 168)) * time.Hour
+}
+
+// JobHistory guarda os jobs encerrados, limitado a JOB_HISTORY_MAX_ENTRIES e
+// JOB_HISTORY_RETENTION_HOURS. Com JOB_HISTORY_FILE cada job encerrado é
+// acrescentado ao arquivo (JSON por linha), recarregado e compactado no start.
+type JobHistory struct {
+	mu         sync.RWMutex
+	path       string
+	maxEntries int
+	retention  time.Duration
+	// records em ordem de término, do mais antigo para o mais recente.
+	records []*JobRecord
+	byID    map[string]*JobRecord
+}
+
+func NewJobHistory() *JobHistory {
+	h := &JobHistory{
+		path:       os.Getenv("JOB_HISTORY_FILE"),
+		maxEntries: getHistoryMaxEntries(),
+		retention:  getHistoryRetention(),
+		byID:       make(map[string]*JobRecord),
+	}
+	if err := h.load(); err != nil {
+		slog.Error("Erro ao carregar histórico de jobs", "component", "history", "path", h.path, "error", err.Error())
+	}
+	return h
+}
+
+// Add guarda o registro, substituindo um anterior com o mesmo conversion_id
+// (um job repetido via retry).
+func (h *JobHistory) Add(rec *JobRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.insert(rec)
+	h.prune()
+
+	if h.path == "" {
+		return
+	}
+	if err := h.appendFile(rec); err != nil {
+		slog.Error("Erro ao persistir histórico de jobs", "component", "history", "path", h.path, "error", err.Error())
+	}
+}
+
+func (h *JobHistory) Get(conversionID string) (*JobRecord, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rec, ok := h.byID[conversionID]
+	return rec, ok
+}
+
+// Records retorna os registros do mais recente para o mais antigo.
+func (h *JobHistory) Records() []*JobRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]*JobRecord, len(h.records))
+	for i, rec := range h.records {
+		out[len(h.records)-1-i] = rec
+	}
+	return out
+}
+
+// insert deve ser chamado com h.mu travado.
+func (h *JobHistory) insert(rec *JobRecord) {
+	if old, ok := h.byID[rec.ConversionID]; ok {
+		for i, r := range h.records {
+			if r == old {
+				h.records = append(h.records[:i], h.records[i+1:]...)
+				break
+			}
+		}
+	}
+	h.records = append(h.records, rec)
+	h.byID[rec.ConversionID] = rec
+}
+
+// prune descarta os registros mais antigos que a retenção ou além do limite.
+// Deve ser chamado com h.mu travado.
+func (h *JobHistory) prune() {
+	cutoff := time.Now().Add(-h.retention)
+	drop := 0
+	for drop < len(h.records) && (len(h.records)-drop > h.maxEntries || finishedBefore(h.records[drop], cutoff)) {
+		delete(h.byID, h.records[drop].ConversionID)
+		drop++
+	}
+	h.records = h.records[drop:]
+}
+
+func finishedBefore(rec *JobRecord, t time.Time) bool {
+	return rec.FinishedAt != nil && rec.FinishedAt.Before(t)
+}
+
+func (h *JobHistory) appendFile(rec *JobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// load lê JOB_HISTORY_FILE e o regrava só com os registros retidos, para que
+// o arquivo não cresça indefinidamente.
+func (h *JobHistory) load() error {
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", h.path, err)
+	}
+
+	var loaded []*JobRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var rec JobRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		loaded = append(loaded, &rec)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", h.path, err)
+	}
+
+	sort.SliceStable(loaded, func(i, j int) bool {
+		return loaded[i].FinishedAt != nil && loaded[j].FinishedAt != nil && loaded[i].FinishedAt.Before(*loaded[j].FinishedAt)
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, rec := range loaded {
+		h.insert(rec)
+	}
+	h.prune()
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, rec := range h.records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	slog.Info("Histórico de jobs carregado", "component", "history", "path", h.path, "jobs", len(h.records))
+	return nil
+}
+
+// JobFilter são os filtros de GET /api/hls/jobs. Datas filtram enqueued_at.
+type JobFilter struct {
+	States      map[string]bool
+	MediaFileID int
+	Tenant      string
+	From, To    time.Time
+	Limit       int
+	Offset      int
+}
+
+func (f JobFilter) match(rec *JobRecord) bool {
+	if len(f.States) > 0 && !f.States[rec.State] {
+		return false
+	}
+	if f.MediaFileID != 0 && rec.MediaFileID != f.MediaFileID {
+		return false
+	}
+	if f.Tenant != "" && rec.Tenant != f.Tenant {
+		return false
+	}
+	if !f.From.IsZero() && rec.EnqueuedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !rec.EnqueuedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// JobList é a resposta paginada de GET /api/hls/jobs.
+type JobList struct {
+	Jobs   []*JobRecord `json:"jobs"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
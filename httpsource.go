@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultSourceAllowedContentTypes = "video/,audio/,application/octet-stream,binary/octet-stream,application/mp4,application/mxf"
+
+const maxSourceRedirects = 10
+
+// HTTPSource baixa originais de URLs HTTP(S), incluindo URLs pré-assinadas do
+// S3 de outras contas. Quedas de conexão são retomadas com requisições Range.
+type HTTPSource struct {
+	client       *http.Client
+	maxBytes     int64
+	maxAttempts  int
+	timeout      time.Duration
+	allowedHosts []string
+	allowedTypes []string
+}
+
+func NewHTTPSource() *HTTPSource {
+	headerTimeout := time.Duration(getEnvInt("SOURCE_HEADER_TIMEOUT_SECONDS", 30)) * time.Second
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !getEnvBool("SOURCE_ALLOW_PRIVATE_NETWORKS", false) {
+		// Confere o IP efetivamente conectado, depois da resolução DNS, para
+		// que um nome público apontando para a rede interna também seja barrado.
+		dialer.Control = rejectPrivateAddress
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: headerTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	h := &HTTPSource{
+		maxBytes:     int64(getEnvInt("SOURCE_MAX_SIZE_MB", 51200)) << 20,
+		maxAttempts:  getEnvInt("SOURCE_MAX_ATTEMPTS", 5),
+		timeout:      time.Duration(getEnvInt("SOURCE_DOWNLOAD_TIMEOUT_SECONDS", 7200)) * time.Second,
+		allowedHosts: splitList(os.Getenv("SOURCE_ALLOWED_HOSTS")),
+		allowedTypes: splitList(envOrDefault("SOURCE_ALLOWED_CONTENT_TYPES", defaultSourceAllowedContentTypes)),
+	}
+	h.client = &http.Client{Transport: transport, CheckRedirect: h.checkRedirect}
+	return h
+}
+
+// checkRedirect aplica a cada redirect as mesmas regras da source_url
+// original: esquema, allowlist de hosts e endereços internos.
+func (h *HTTPSource) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxSourceRedirects {
+		return &permanentSourceError{fmt.Errorf("mais de %d redirects em source_url", maxSourceRedirects)}
+	}
+	if err := h.Validate(req.URL.String()); err != nil {
+		return &permanentSourceError{fmt.Errorf("redirect recusado para %s: %w", redactURL(req.URL.String()), err)}
+	}
+	return nil
+}
+
+// isPrivateAddress indica se o IP é de loopback, link-local (como o endpoint
+// de metadados 169.254.169.254), rede privada ou não especificado.
+func isPrivateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// rejectPrivateAddress é o Control do dialer: recusa conexões a endereços
+// internos antes de abri-las.
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return &permanentSourceError{err}
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateAddress(ip) {
+		return &permanentSourceError{fmt.Errorf("endereço %s não permitido em source_url", host)}
+	}
+	return nil
+}
+
+func envOrDefault(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, strings.ToLower(item))
+		}
+	}
+	return out
+}
+
+// redactURL remove a query string (assinaturas de URLs pré-assinadas) dos logs.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<url inválida>"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "..."
+	}
+	u.User = nil
+	return u.String()
+}
+
+// sourceURLExt retorna a extensão do caminho da URL, ignorando a query string.
+func sourceURLExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return path.Ext(u.Path)
+}
+
+// Validate verifica esquema e host antes de aceitar a requisição e a cada
+// redirect.
+func (h *HTTPSource) Validate(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("source_url inválida: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("source_url deve usar http ou https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("source_url sem host")
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil && isPrivateAddress(ip) && !getEnvBool("SOURCE_ALLOW_PRIVATE_NETWORKS", false) {
+		return fmt.Errorf("endereço %s não permitido em source_url", host)
+	}
+	if len(h.allowedHosts) == 0 {
+		return nil
+	}
+	for _, allowed := range h.allowedHosts {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s não permitido em source_url", host)
+}
+
+func (h *HTTPSource) contentTypeAllowed(header string) bool {
+	if header == "" || len(h.allowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range h.allowedTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// permanentSourceError marca erros em que tentar novamente não adianta.
+type permanentSourceError struct{ err error }
+
+func (e *permanentSourceError) Error() string { return e.err.Error() }
+func (e *permanentSourceError) Unwrap() error { return e.err }
+
+// sourceStatusError é uma resposta HTTP inesperada do servidor de origem.
+type sourceStatusError struct{ code int }
+
+func (e *sourceStatusError) Error() string { return fmt.Sprintf("status HTTP %d", e.code) }
+
+func (h *HTTPSource) Download(ctx context.Context, rawURL string, localPath string) error {
+	if err := h.Validate(rawURL); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("erro ao criar diretório %s: %w", filepath.Dir(localPath), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("erro ao criar arquivo local: %w", err)
+	}
+	defer file.Close()
+
+	logger := ctxLogger(ctx, "source").With("phase", PhaseDownload)
+	logger.Info("Baixando original via HTTP", "source_url", redactURL(rawURL), "local_path", localPath)
+	start := time.Now()
+
+	var (
+		offset int64
+		total  int64 = -1
+	)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		done, err := h.fetch(ctx, rawURL, file, &offset, &total)
+		if done {
+			logger.Info("Download concluído", "bytes", offset, seconds(time.Since(start)))
+			return nil
+		}
+
+		if !isTransientError(err) || ctx.Err() != nil || attempt >= h.maxAttempts {
+			if ctx.Err() != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timeout ao baixar source_url após %s", h.timeout)
+			}
+			return fmt.Errorf("erro ao baixar source_url: %w", err)
+		}
+
+		logger.Warn("Download interrompido, retomando", "attempt", attempt, "max_attempts", h.maxAttempts, "bytes", offset, "retry_in", backoff.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("erro ao baixar source_url: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// fetch faz uma requisição a partir de *offset, gravando no arquivo. Retorna
+// done=true quando o arquivo está completo.
+func (h *HTTPSource) fetch(ctx context.Context, rawURL string, file *os.File, offset *int64, total *int64) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, &permanentSourceError{err}
+	}
+	if *offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", *offset))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// O servidor ignorou o Range (ou é a primeira requisição): recomeça do zero.
+		if *offset > 0 {
+			ctxLogger(ctx, "source").Warn("Servidor não suporta Range, reiniciando download", "phase", PhaseDownload)
+			if err := file.Truncate(0); err != nil {
+				return false, &permanentSourceError{err}
+			}
+			*offset = 0
+		}
+		*total = resp.ContentLength
+	case resp.StatusCode == http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != *offset {
+			return false, &permanentSourceError{fmt.Errorf("Content-Range inesperado: %q", resp.Header.Get("Content-Range"))}
+		}
+		*total = size
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && *total >= 0 && *offset == *total:
+		return true, nil
+	default:
+		return false, &sourceStatusError{resp.StatusCode}
+	}
+
+	if ct := resp.Header.Get("Content-Type"); !h.contentTypeAllowed(ct) {
+		return false, &permanentSourceError{fmt.Errorf("Content-Type não permitido: %s", ct)}
+	}
+	if h.maxBytes > 0 && *total > h.maxBytes {
+		return false, &permanentSourceError{fmt.Errorf("arquivo excede o limite de %d bytes (%d bytes)", h.maxBytes, *total)}
+	}
+
+	if _, err := file.Seek(*offset, io.SeekStart); err != nil {
+		return false, &permanentSourceError{err}
+	}
+
+	body := io.Reader(resp.Body)
+	if h.maxBytes > 0 {
+		// Lê um byte além do limite para detectar servidores sem Content-Length.
+		body = io.LimitReader(resp.Body, h.maxBytes-*offset+1)
+	}
+	written, copyErr := io.Copy(file, body)
+	*offset += written
+	metricBytesDownloaded.WithLabelValues("http").Add(float64(written))
+
+	if h.maxBytes > 0 && *offset > h.maxBytes {
+		return false, &permanentSourceError{fmt.Errorf("arquivo excede o limite de %d bytes", h.maxBytes)}
+	}
+	if copyErr != nil {
+		return false, copyErr
+	}
+	if *total >= 0 && *offset < *total {
+		return false, fmt.Errorf("conexão encerrada em %d de %d bytes: %w", *offset, *total, io.ErrUnexpectedEOF)
+	}
+	return true, nil
+}
+
+// parseContentRange interpreta "bytes start-end/size". size é -1 quando "*".
+func parseContentRange(v string) (start int64, size int64, ok bool) {
+	v = strings.TrimPrefix(v, "bytes ")
+	rangePart, sizePart, found := strings.Cut(v, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if sizePart == "*" {
+		return start, -1, true
+	}
+	size, err = strconv.ParseInt(sizePart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Políticas para uma nova submissão de mídia que já tem job ativo.
+const (
+	ConflictReject    = "reject"
+	ConflictSupersede = "supersede"
+	ConflictAttach    = "attach"
+)
+
+var ErrIdempotencyMismatch = errors.New("Idempotency-Key reutilizada com payload diferente")
+
+func getConflictPolicy(req ConvertRequest) string {
+	policy := req.OnConflict
+	if policy == "" {
+		policy = envOrDefault("CONFLICT_POLICY", ConflictReject)
+	}
+	return policy
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case "", ConflictReject, ConflictSupersede, ConflictAttach:
+		return true
+	}
+	return false
+}
+
+type idempotencyEntry struct {
+	conversionID string
+	fingerprint  string
+	expires      time.Time
+}
+
+// IdempotencyStore lembra, por IDEMPOTENCY_TTL_HOURS, qual conversion_id foi
+// criado para cada Idempotency-Key. O Laravel pode repetir o POST após um
+// timeout e recebe o mesmo conversion_id em vez de um segundo job.
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]idempotencyEntry
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     time.Duration(getEnvInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		entries: make(map[string]idempotencyEntry),
+	}
+}
+
+// requestFingerprint identifica o payload para detectar reuso da mesma chave
+// com uma requisição diferente.
+func requestFingerprint(req ConvertRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Do executa create uma única vez por chave. Chamadas repetidas dentro do TTL
+// retornam o conversion_id original com replayed=true. O lock cobre create
+// para que duas requisições simultâneas com a mesma chave não criem dois jobs.
+func (s *IdempotencyStore) Do(key string, fingerprint string, create func() (string, error)) (conversionID string, replayed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		if e.fingerprint != fingerprint {
+			return "", false, ErrIdempotencyMismatch
+		}
+		return e.conversionID, true, nil
+	}
+
+	conversionID, err = create()
+	if err != nil {
+		return "", false, err
+	}
+
+	s.prune(now)
+	s.entries[key] = idempotencyEntry{conversionID: conversionID, fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return conversionID, false, nil
+}
+
+func (s *IdempotencyStore) prune(now time.Time) {
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
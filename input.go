@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	InputModeDownload = "download"
+	InputModeStream   = "stream"
+	InputModeAuto     = "auto"
+)
+
+// Formatos em que o ffmpeg faz muitos seeks (índice no fim, containers sem
+// suporte a leitura sequencial): no modo auto são sempre baixados.
+const defaultDownloadOnlyExtensions = ".avi,.wmv,.flv,.mpg,.mpeg,.vob"
+
+// StreamableStorage é implementado pelos backends capazes de entregar ao
+// ffmpeg um endereço de leitura direta, sem cópia para TEMP_DIR.
+type StreamableStorage interface {
+	InputURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+func getInputMode(req ConvertRequest) string {
+	mode := req.InputMode
+	if mode == "" {
+		mode = os.Getenv("INPUT_MODE")
+	}
+	switch strings.ToLower(mode) {
+	case InputModeStream:
+		return InputModeStream
+	case InputModeAuto:
+		return InputModeAuto
+	default:
+		return InputModeDownload
+	}
+}
+
+func validInputMode(mode string) bool {
+	switch mode {
+	case "", InputModeDownload, InputModeStream, InputModeAuto:
+		return true
+	}
+	return false
+}
+
+// sourceInput representa o original de um job: um arquivo já baixado ou uma
+// URL que o ffmpeg lê diretamente.
+type sourceInput struct {
+	req       ConvertRequest
+	storage   Storage
+	localPath string
+	streaming bool
+}
+
+func (s *sourceInput) ext() string {
+	if s.req.SourceURL != "" {
+		return sourceURLExt(s.req.SourceURL)
+	}
+	return filepath.Ext(s.req.S3Path)
+}
+
+// streamable indica se o original pode ser lido direto pelo ffmpeg. source_url
+// é sempre baixada pelo HTTPSource, que aplica a allowlist de hosts (inclusive
+// nos redirects), o bloqueio de endereços internos, o limite de tamanho e o
+// Content-Type; o ffmpeg não aplica nenhum deles.
+func (s *sourceInput) streamable() bool {
+	if s.req.SourceURL != "" {
+		return false
+	}
+	_, ok := s.storage.(StreamableStorage)
+	return ok
+}
+
+// prepareInput decide entre streaming e download completo conforme o modo.
+func prepareInput(ctx context.Context, req ConvertRequest, storage Storage, tempDir string) (*sourceInput, error) {
+	in := &sourceInput{req: req, storage: storage}
+
+	mode := getInputMode(req)
+	if mode == InputModeAuto {
+		mode = InputModeStream
+		ext := strings.ToLower(in.ext())
+		for _, e := range splitList(envOrDefault("STREAM_DOWNLOAD_EXTENSIONS", defaultDownloadOnlyExtensions)) {
+			if ext == e {
+				mode = InputModeDownload
+				break
+			}
+		}
+	}
+
+	if mode == InputModeStream && in.streamable() {
+		ctxLogger(ctx, "converter").Info("Original será lido por streaming, sem download completo", "phase", PhaseDownload)
+		in.streaming = true
+		return in, nil
+	}
+
+	return in, in.download(ctx, tempDir)
+}
+
+func (s *sourceInput) download(ctx context.Context, tempDir string) error {
+	s.localPath = filepath.Join(tempDir, "original"+s.ext())
+	s.streaming = false
+
+	if s.req.SourceURL != "" {
+		ctxLogger(ctx, "converter").Info("Baixando arquivo original", "phase", PhaseDownload, "source_url", redactURL(s.req.SourceURL))
+		// HTTPSource já retoma e repete o download (SOURCE_MAX_ATTEMPTS).
+		return NewHTTPSource().Download(ctx, s.req.SourceURL, s.localPath)
+	}
+	ctxLogger(ctx, "converter").Info("Baixando arquivo original", "phase", PhaseDownload, "key", s.req.S3Path)
+	return retryPhase(ctx, PhaseDownload, func() error {
+		return s.storage.Download(ctx, s.req.S3Path, s.localPath)
+	})
+}
+
+// Path retorna o que deve ser passado ao "-i" do ffmpeg. Em streaming a URL é
+// gerada a cada chamada para que a assinatura não expire entre qualidades.
+func (s *sourceInput) Path(ctx context.Context) (string, error) {
+	if !s.streaming {
+		return s.localPath, nil
+	}
+	ttl := time.Duration(getEnvInt("INPUT_PRESIGN_TTL_MINUTES", 720)) * time.Minute
+	u, err := s.storage.(StreamableStorage).InputURL(ctx, s.req.S3Path, ttl)
+	if err != nil {
+		return "", fmt.Errorf("erro ao gerar URL de leitura do original: %w", err)
+	}
+	return u, nil
+}
+
+// isStreamReadError indica se a falha em streaming veio do ffmpeg (leitura ou
+// seek no original), caso em que vale baixar o original completo. Falhas de
+// upload ou ao gerar a URL de leitura não mudam com o download.
+func isStreamReadError(err error) bool {
+	var fe *ffmpegError
+	return errors.As(err, &fe) && !fe.signaled
+}
+
+// inputArgs monta os argumentos de entrada do ffmpeg, habilitando reconexão
+// quando o original é lido por HTTP.
+func inputArgs(input string) []string {
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		return []string{
+			"-reconnect", "1",
+			"-reconnect_on_network_error", "1",
+			"-reconnect_delay_max", "30",
+			"-i", input,
+		}
+	}
+	return []string{"-i", input}
+}
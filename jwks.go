@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Intervalo mínimo entre recargas disparadas por kid desconhecido, para que
+// tokens forjados com kids aleatórios não virem uma enxurrada de requisições
+// ao IdP.
+const jwksMinRefetchInterval = time.Minute
+
+// JWKS mantém as chaves públicas usadas para validar os JWT, lidas de
+// JWT_JWKS_FILE ou baixadas de JWT_JWKS_URL. Com URL, as chaves são
+// recarregadas a cada JWT_JWKS_REFRESH_MINUTES e também quando chega um kid
+// desconhecido (no máximo uma vez por minuto), para acompanhar rotações.
+// A recarga roda fora do lock e é única: requisições concorrentes esperam a
+// mesma.
+type JWKS struct {
+	url     string
+	file    string
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	tried   time.Time
+	loading chan struct{} // fechado quando a recarga em andamento termina
+}
+
+func NewJWKS(url, file string) (*JWKS, error) {
+	j := &JWKS{
+		url:     url,
+		file:    file,
+		refresh: time.Duration(getEnvInt("JWT_JWKS_REFRESH_MINUTES", 60)) * time.Minute,
+	}
+	keys, err := j.read(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	j.keys, j.fetched, j.tried = keys, time.Now(), time.Now()
+	return j, nil
+}
+
+// Key retorna a chave pública do kid informado. Um kid conhecido responde na
+// hora, mesmo com o JWKS vencido (a recarga segue em segundo plano); um kid
+// desconhecido espera a recarga em andamento ou dispara uma, respeitando
+// jwksMinRefetchInterval.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	key, known := j.keys[kid]
+	var wait <-chan struct{}
+	if j.url != "" && (!known || time.Since(j.fetched) > j.refresh) {
+		wait = j.startRefresh()
+	}
+	j.mu.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if wait != nil {
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		j.mu.Lock()
+		key, known = j.keys[kid]
+		j.mu.Unlock()
+		if known {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("kid desconhecido: %q", kid)
+}
+
+// startRefresh inicia uma recarga, se nenhuma estiver em andamento e a última
+// tentativa tiver mais de jwksMinRefetchInterval, e retorna o canal da recarga
+// em andamento (nil se não há nenhuma). Deve ser chamado com j.mu travado.
+func (j *JWKS) startRefresh() <-chan struct{} {
+	if j.loading == nil && time.Since(j.tried) > jwksMinRefetchInterval {
+		j.tried = time.Now()
+		done := make(chan struct{})
+		j.loading = done
+		go func() {
+			// Não usa o contexto da requisição: outras esperam o mesmo resultado.
+			keys, err := j.read(context.Background())
+			j.mu.Lock()
+			if err != nil {
+				// Mantém as chaves anteriores: o IdP fora do ar não deve derrubar
+				// tokens que ainda são válidos.
+				slog.Warn("Erro ao recarregar JWKS", "component", "auth", "error", err.Error())
+			} else {
+				j.keys, j.fetched = keys, time.Now()
+			}
+			j.loading = nil
+			j.mu.Unlock()
+			close(done)
+		}()
+	}
+	return j.loading
+}
+
+// read baixa (ou lê do arquivo) e interpreta o JWKS, sem tocar no estado.
+func (j *JWKS) read(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if j.url != "" {
+		data, err = fetchJWKS(ctx, j.url)
+	} else {
+		data, err = os.ReadFile(j.file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS inválido: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("Chave JWKS ignorada", "component", "auth", "kid", k.Kid, "error", err.Error())
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS sem chaves de assinatura suportadas")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curva não suportada: %s", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ponto fora da curva")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("kty não suportado: %s", k.Kty)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("inteiro base64url inválido")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
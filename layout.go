@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Layout padrão das chaves de saída: hls/123/720p/master.m3u8 e
+// hls/123/master.m3u8.
+const (
+	defaultRenditionTemplate = "hls/{media_id}/{quality}"
+	defaultMasterTemplate    = "hls/{media_id}/master.m3u8"
+)
+
+var templateVar = regexp.MustCompile(`\{[a-z_]+\}`)
+
+var templateVars = map[string]bool{
+	"{media_id}": true, "{conversion_id}": true, "{quality}": true, "{tenant}": true,
+	"{title_slug}": true, "{date}": true, "{year}": true, "{month}": true, "{day}": true,
+}
+
+// OutputLayout define onde as renditions e a master playlist de um job são
+// gravadas. Os templates vêm do request, do tenant ou de
+// OUTPUT_RENDITION_TEMPLATE/OUTPUT_MASTER_TEMPLATE, nessa ordem.
+type OutputLayout struct {
+	renditionTemplate string
+	masterTemplate    string
+	replacer          *strings.Replacer
+}
+
+// outputLayout monta o layout do job. As datas usam o momento da submissão
+// (UTC), para que todas as qualidades caiam no mesmo diretório.
+func outputLayout(job *ConversionJob) OutputLayout {
+	req := job.Request
+	rendition, master := outputTemplates(req)
+
+	date := job.EnqueuedAt.UTC()
+	if date.IsZero() {
+		date = time.Now().UTC()
+	}
+
+	return OutputLayout{
+		renditionTemplate: rendition,
+		masterTemplate:    master,
+		replacer: strings.NewReplacer(
+			"{media_id}", strconv.Itoa(req.MediaFileID),
+			"{conversion_id}", job.ID,
+			"{tenant}", req.Tenant,
+			"{title_slug}", slugify(req.Title),
+			"{date}", date.Format("2006-01-02"),
+			"{year}", date.Format("2006"),
+			"{month}", date.Format("01"),
+			"{day}", date.Format("02"),
+		),
+	}
+}
+
+func outputTemplates(req ConvertRequest) (rendition, master string) {
+	rendition, master = defaultRenditionTemplate, defaultMasterTemplate
+	if publishMode(req) == PublishVersioned {
+		rendition = defaultVersionedRenditionTemplate
+	}
+	if v := os.Getenv("OUTPUT_RENDITION_TEMPLATE"); v != "" {
+		rendition = v
+	}
+	if v := os.Getenv("OUTPUT_MASTER_TEMPLATE"); v != "" {
+		master = v
+	}
+	t := tenants.Lookup(req.Tenant)
+	// Tenants que gravam no storage global ficam sob {tenant}/, para que o
+	// mesmo media_file_id de dois tenants não caia nas mesmas chaves.
+	if req.Tenant != "" && (t == nil || t.Bucket == "") {
+		rendition, master = tenantScoped(rendition), tenantScoped(master)
+	}
+	if t != nil {
+		if t.RenditionTemplate != "" {
+			rendition = t.RenditionTemplate
+		}
+		if t.MasterTemplate != "" {
+			master = t.MasterTemplate
+		}
+	}
+	if req.RenditionTemplate != "" {
+		rendition = req.RenditionTemplate
+	}
+	if req.MasterTemplate != "" {
+		master = req.MasterTemplate
+	}
+	return rendition, master
+}
+
+// tenantScoped prefixa o template com {tenant}/, se ele ainda não o usa.
+func tenantScoped(tmpl string) string {
+	if strings.Contains(tmpl, "{tenant}") {
+		return tmpl
+	}
+	return "{tenant}/" + tmpl
+}
+
+// validateOutputTemplates recusa templates que gerariam chaves colidindo
+// entre qualidades ou entre mídias, ou fora do bucket/raiz.
+func validateOutputTemplates(rendition, master string) error {
+	for _, tmpl := range []string{rendition, master} {
+		// Sem {media_id} nem {conversion_id} todas as mídias escreveriam nas
+		// mesmas chaves, e o delete e a coleta de versões apagariam as de outras.
+		if !strings.Contains(tmpl, "{media_id}") && !strings.Contains(tmpl, "{conversion_id}") {
+			return fmt.Errorf("template %q deve conter {media_id} ou {conversion_id}", tmpl)
+		}
+		for _, v := range templateVar.FindAllString(tmpl, -1) {
+			if !templateVars[v] {
+				return fmt.Errorf("variável desconhecida no template %q: %s", tmpl, v)
+			}
+		}
+		if strings.HasPrefix(tmpl, "/") || strings.Contains("/"+tmpl+"/", "/../") {
+			return fmt.Errorf("template %q deve ser um caminho relativo sem '..'", tmpl)
+		}
+	}
+	if !strings.Contains(rendition, "{quality}") {
+		return errors.New("o template das renditions deve conter {quality}")
+	}
+	if strings.Contains(master, "{quality}") {
+		return errors.New("o template da master playlist não pode conter {quality}")
+	}
+	if !strings.HasSuffix(master, ".m3u8") {
+		return errors.New("o template da master playlist deve terminar em .m3u8")
+	}
+	return nil
+}
+
+// validateLayout valida os templates efetivos do request e o modo de
+// publicação: no modo versioned cada conversão precisa de um prefixo próprio,
+// e o repair precisa de um layout estável.
+func validateLayout(req ConvertRequest) error {
+	mode := publishMode(req)
+	if mode != PublishInPlace && mode != PublishVersioned {
+		return fmt.Errorf("PUBLISH_MODE deve ser in_place ou versioned: %s", mode)
+	}
+	rendition, master := outputTemplates(req)
+	if err := validateOutputTemplates(rendition, master); err != nil {
+		return err
+	}
+	if mode == PublishVersioned && !strings.Contains(rendition, "{conversion_id}") {
+		return errors.New("no modo versioned o template das renditions deve conter {conversion_id}")
+	}
+	if req.Mode == ConvertModeRepair {
+		return validateRepairLayout(req)
+	}
+	return nil
+}
+
+// RenditionDir é o prefixo onde a playlist e os segmentos da qualidade são
+// gravados.
+func (l OutputLayout) RenditionDir(quality string) string {
+	return path.Clean(strings.ReplaceAll(l.replacer.Replace(l.renditionTemplate), "{quality}", quality))
+}
+
+// RenditionPlaylist é a chave da playlist da qualidade, enviada no callback.
+func (l OutputLayout) RenditionPlaylist(quality string) string {
+	return l.RenditionDir(quality) + "/master.m3u8"
+}
+
+// MasterPlaylist é a chave da master playlist da mídia.
+func (l OutputLayout) MasterPlaylist() string {
+	return path.Clean(l.replacer.Replace(l.masterTemplate))
+}
+
+// relativePath é a URI de to relativa ao diretório fromDir, para que o player
+// resolva as renditions a partir da master playlist em qualquer layout.
+func relativePath(fromDir, to string) string {
+	from := strings.Split(fromDir, "/")
+	parts := strings.Split(to, "/")
+	if fromDir == "." {
+		return to
+	}
+
+	i := 0
+	for i < len(from) && i < len(parts)-1 && from[i] == parts[i] {
+		i++
+	}
+	rel := strings.Repeat("../", len(from)-i)
+	return rel + strings.Join(parts[i:], "/")
+}
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify converte o título em algo seguro para chaves: "Aula 1: Introdução"
+// vira "aula-1-introducao".
+func slugify(title string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, err := transform.String(t, strings.ToLower(title))
+	if err != nil {
+		s = strings.ToLower(title)
+	}
+	s = strings.Trim(slugSeparators.ReplaceAllString(s, "-"), "-")
+	if len(s) > 80 {
+		s = strings.TrimRight(s[:80], "-")
+	}
+	if s == "" {
+		return "sem-titulo"
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+// withTenants troca o registro global de tenants durante o teste.
+func withTenants(t *testing.T, list map[string]*Tenant) {
+	t.Helper()
+	prev := tenants
+	for name, tenant := range list {
+		tenant.Name = name
+	}
+	tenants = &TenantRegistry{tenants: list, usage: newUsageStore("")}
+	t.Cleanup(func() { tenants = prev })
+}
+
+func TestRelativePath(t *testing.T) {
+	tests := []struct {
+		fromDir, to, want string
+	}{
+		{"hls/123", "hls/123/720p/master.m3u8", "720p/master.m3u8"},
+		{"hls/123", "hls/123/v/abc/720p/master.m3u8", "v/abc/720p/master.m3u8"},
+		{"masters/123", "renditions/123/720p/master.m3u8", "../../renditions/123/720p/master.m3u8"},
+		{"a/b/c", "a/x/720p/master.m3u8", "../../x/720p/master.m3u8"},
+		{".", "720p/master.m3u8", "720p/master.m3u8"},
+		// hls/12 não é prefixo de hls/123.
+		{"hls/12", "hls/123/720p/master.m3u8", "../123/720p/master.m3u8"},
+	}
+	for _, tt := range tests {
+		if got := relativePath(tt.fromDir, tt.to); got != tt.want {
+			t.Errorf("relativePath(%q, %q) = %q, want %q", tt.fromDir, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestValidateOutputTemplates(t *testing.T) {
+	tests := []struct {
+		name, rendition, master string
+		ok                      bool
+	}{
+		{"padrão", defaultRenditionTemplate, defaultMasterTemplate, true},
+		{"com datas e slug", "{tenant}/{year}/{month}/{title_slug}-{media_id}/{quality}", "{tenant}/{date}/{media_id}.m3u8", true},
+		{"variável desconhecida", "hls/{media}/{quality}", defaultMasterTemplate, false},
+		{"caminho absoluto", "/hls/{media_id}/{quality}", defaultMasterTemplate, false},
+		{"sobe diretório", "hls/../{media_id}/{quality}", defaultMasterTemplate, false},
+		{"master sobe diretório", defaultRenditionTemplate, "../{media_id}/master.m3u8", false},
+		{"renditions sem quality", "hls/{media_id}", defaultMasterTemplate, false},
+		{"master com quality", defaultRenditionTemplate, "hls/{media_id}/{quality}.m3u8", false},
+		{"master sem .m3u8", defaultRenditionTemplate, "hls/{media_id}/master", false},
+		{"renditions sem media_id", "hls/{title_slug}/{quality}", defaultMasterTemplate, false},
+		{"master sem media_id", defaultRenditionTemplate, "hls/{tenant}/master.m3u8", false},
+		{"só conversion_id", "hls/{conversion_id}/{quality}", "hls/{conversion_id}/master.m3u8", true},
+	}
+	for _, tt := range tests {
+		err := validateOutputTemplates(tt.rendition, tt.master)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: validateOutputTemplates(%q, %q) = %v, want ok=%v", tt.name, tt.rendition, tt.master, err, tt.ok)
+		}
+	}
+}
+
+func TestOutputTemplatesTenantScope(t *testing.T) {
+	t.Setenv("PUBLISH_MODE", PublishInPlace)
+	withTenants(t, map[string]*Tenant{
+		"shared":    {},
+		"own":       {Bucket: "own-bucket"},
+		"templated": {RenditionTemplate: "{tenant}/r/{media_id}/{quality}", MasterTemplate: "{tenant}/m/{media_id}.m3u8"},
+	})
+
+	tests := []struct {
+		tenant, rendition, master string
+	}{
+		{"", "hls/{media_id}/{quality}", "hls/{media_id}/master.m3u8"},
+		{"shared", "{tenant}/hls/{media_id}/{quality}", "{tenant}/hls/{media_id}/master.m3u8"},
+		{"own", "hls/{media_id}/{quality}", "hls/{media_id}/master.m3u8"},
+		{"templated", "{tenant}/r/{media_id}/{quality}", "{tenant}/m/{media_id}.m3u8"},
+	}
+	for _, tt := range tests {
+		rendition, master := outputTemplates(ConvertRequest{Tenant: tt.tenant})
+		if rendition != tt.rendition || master != tt.master {
+			t.Errorf("tenant %q: outputTemplates = (%q, %q), want (%q, %q)", tt.tenant, rendition, master, tt.rendition, tt.master)
+		}
+	}
+}
+
+func TestOutputLayoutKeys(t *testing.T) {
+	t.Setenv("PUBLISH_MODE", PublishInPlace)
+	withTenants(t, map[string]*Tenant{"acme": {}})
+
+	job := &ConversionJob{ID: "conv-1", Request: ConvertRequest{MediaFileID: 123, Tenant: "acme"}}
+	layout := outputLayout(job)
+	if got, want := layout.RenditionPlaylist("720p"), "acme/hls/123/720p/master.m3u8"; got != want {
+		t.Errorf("RenditionPlaylist = %q, want %q", got, want)
+	}
+	if got, want := layout.MasterPlaylist(), "acme/hls/123/master.m3u8"; got != want {
+		t.Errorf("MasterPlaylist = %q, want %q", got, want)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Aula 1: Introdução":    "aula-1-introducao",
+		"  --Ação & Reação--  ": "acao-reacao",
+		"":                      "sem-titulo",
+	}
+	for in, want := range tests {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage grava e lê objetos em um diretório do sistema de arquivos,
+// usando a chave como caminho relativo à raiz.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("LOCAL_STORAGE_ROOT não configurado")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("caminho inválido para storage local %s: %w", root, err)
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório %s: %w", abs, err)
+	}
+	return &LocalStorage{root: abs}, nil
+}
+
+// resolve converte a chave em caminho local, impedindo que "../" escape da raiz.
+func (l *LocalStorage) resolve(key string) (string, error) {
+	clean := path.Clean("/" + strings.TrimPrefix(key, "/"))
+	if clean == "/" {
+		return "", fmt.Errorf("chave inválida: %q", key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+func (l *LocalStorage) Download(ctx context.Context, key string, localPath string) error {
+	src, err := l.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	logger := ctxLogger(ctx, "storage").With("phase", PhaseDownload)
+	logger.Info("Copiando do storage local", "key", key, "local_path", localPath)
+
+	written, err := copyFile(ctx, src, localPath)
+	if err != nil {
+		return fmt.Errorf("erro ao baixar do storage local: %w", err)
+	}
+
+	metricBytesDownloaded.WithLabelValues(StorageBackendLocal).Add(float64(written))
+	logger.Info("Download concluído", "bytes", written)
+	return nil
+}
+
+func (l *LocalStorage) Upload(ctx context.Context, localPath string, key string) error {
+	dst, err := l.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	ctxLogger(ctx, "storage").Debug("Copiando para o storage local", "phase", PhaseUpload, "local_path", localPath, "key", key)
+
+	// Escreve em arquivo temporário e renomeia para que leitores nunca vejam
+	// um arquivo pela metade. O nome é único: dois uploads da mesma key (um
+	// retry ainda em andamento, duas réplicas) não escrevem no mesmo arquivo.
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("erro ao enviar para storage local: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.part")
+	if err != nil {
+		return fmt.Errorf("erro ao enviar para storage local: %w", err)
+	}
+	// CreateTemp cria com 0600; o storage é lido por quem serve os arquivos.
+	err = tmp.Chmod(0644)
+	var written int64
+	if err == nil {
+		written, err = copyTo(ctx, localPath, tmp)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("erro ao enviar para storage local: %w", err)
+	}
+	metricBytesUploaded.WithLabelValues(StorageBackendLocal).Add(float64(written))
+	return nil
+}
+
+func (l *LocalStorage) UploadDirectory(ctx context.Context, localDir string, prefix string) error {
+	var playlists []uploadItem
+	err := filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+
+		key := prefix + "/" + filepath.ToSlash(relPath)
+		// Assim como no S3, playlists vão por último.
+		if isPlaylist(p) {
+			playlists = append(playlists, uploadItem{localPath: p, s3Key: key})
+			return nil
+		}
+		return l.Upload(ctx, p, key)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range playlists {
+		if err := l.Upload(ctx, item.localPath, item.s3Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := l.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("erro ao remover %s: %w", p, err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	prefix, err := dirPrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	dir, err := l.resolve(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			deleted++
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, fmt.Errorf("erro ao remover %s: %w", dir, err)
+	}
+	ctxLogger(ctx, "storage").Info("Prefixo removido do storage local", "prefix", prefix, "objects", deleted)
+	return deleted, nil
+}
+
+func (l *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := l.resolve(key)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// CheckHealth confirma que a raiz existe e aceita escrita.
+func (l *LocalStorage) CheckHealth(ctx context.Context) error {
+	f, err := os.CreateTemp(l.root, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("sem permissão de escrita em %s: %w", l.root, err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// InputURL devolve o próprio caminho do arquivo: o ffmpeg lê direto da raiz.
+func (l *LocalStorage) InputURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	p, err := l.resolve(key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func copyFile(ctx context.Context, src string, dst string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := copyTo(ctx, src, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return written, err
+}
+
+// copyTo copia src para out, que continua aberto.
+func copyTo(ctx context.Context, src string, out io.Writer) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	return io.Copy(out, in)
+}
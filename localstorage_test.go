@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLocalStorageConcurrentUpload(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Uploads simultâneos da mesma key não podem escrever no mesmo arquivo
+	// temporário: o resultado é sempre um dos originais inteiro.
+	src := t.TempDir()
+	var contents []string
+	for i := 0; i < 8; i++ {
+		content := strings.Repeat(fmt.Sprint(i), 256<<10)
+		contents = append(contents, content)
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprint(i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(contents))
+	for i := range contents {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- storage.Upload(context.Background(), filepath.Join(src, fmt.Sprint(i)), "hls/123/720p/seg_000.ts")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(storage.root, "hls/123/720p")
+	got, err := os.ReadFile(filepath.Join(dir, "seg_000.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range contents {
+		found = found || string(got) == c
+	}
+	if !found {
+		t.Error("arquivo final mistura uploads diferentes")
+	}
+
+	// Nenhum temporário fica para trás.
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("arquivos em %s = %v, want só seg_000.ts", dir, names)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Fases usadas no campo "phase" dos logs.
+const (
+	PhaseQueue    = "queue"
+	PhaseDownload = "download"
+	PhaseEncode   = "encode"
+	PhaseUpload   = "upload"
+	PhasePlaylist = "playlist"
+	PhaseCallback = "callback"
+	PhaseCleanup  = "cleanup"
+	PhasePaused   = "paused"
+)
+
+// initLogging configura o logger padrão a partir de LOG_LEVEL (debug, info,
+// warn, error) e LOG_FORMAT (json ou text). O pacote log da stdlib passa a
+// escrever pelo mesmo handler.
+func initLogging() {
+	var level slog.Level
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "text" {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+type loggerKey struct{}
+
+// withLogger guarda no contexto um logger com os campos de correlação do job,
+// para que S3, storage e callbacks registrem conversion_id/quality sem
+// precisarem conhecer o job.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFromContext retorna o logger guardado no contexto, ou o padrão.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ctxLogger retorna o logger do contexto marcado com o componente.
+func ctxLogger(ctx context.Context, component string) *slog.Logger {
+	return loggerFromContext(ctx).With("component", component)
+}
+
+func jobLogger(job *ConversionJob) *slog.Logger {
+	logger := slog.Default().With(
+		"conversion_id", job.ID,
+		"media_file_id", job.Request.MediaFileID,
+	)
+	if job.Request.Tenant != "" {
+		logger = logger.With("tenant", job.Request.Tenant)
+	}
+	return logger
+}
+
+// seconds formata durações no campo "duration", sempre em segundos.
+func seconds(d time.Duration) slog.Attr {
+	return slog.Float64("duration", d.Seconds())
+}
+
+// ffmpegError carrega o stderr do ffmpeg separado da mensagem, para que os
+// logs o registrem em um campo próprio.
+type ffmpegError struct {
+	err    error
+	stderr string
+
+	// signaled indica que o processo foi morto por sinal (OOM killer, por
+	// exemplo), e não que terminou com erro.
+	signaled bool
+}
+
+func (e *ffmpegError) Error() string {
+	return "ffmpeg falhou: " + e.err.Error() + " - " + e.stderr
+}
+
+func (e *ffmpegError) Unwrap() error { return e.err }
+
+// truncateStderr mantém apenas o final do stderr, onde o ffmpeg escreve a causa do erro.
+func truncateStderr(stderr string) string {
+	max := getEnvInt("FFMPEG_STDERR_MAX_BYTES", 4096)
+	if max <= 0 || len(stderr) <= max {
+		return stderr
+	}
+	return "..." + stderr[len(stderr)-max:]
+}
+
+// errAttrs retorna os atributos de log de um erro, com o stderr do ffmpeg à parte.
+func errAttrs(err error) []any {
+	var fe *ffmpegError
+	if errors.As(err, &fe) {
+		return []any{"error", "ffmpeg falhou: " + fe.err.Error(), "ffmpeg_stderr", fe.stderr}
+	}
+	return []any{"error", err.Error()}
+}
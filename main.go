@@ -1,23 +1,34 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-	log.Println("[MAIN] Iniciando serviço HLS Converter")
+	// Load .env file (ignore error if not present). Must run before
+	// initLogging so LOG_LEVEL/LOG_FORMAT from .env are honored.
+	envErr := godotenv.Load()
+	initLogging()
 
-	// Load .env file (ignore error if not present)
-	if err := godotenv.Load(); err != nil {
-		log.Println("[MAIN] Arquivo .env não encontrado, usando variáveis de ambiente do sistema")
+	slog.Info("Iniciando serviço HLS Converter", "component", "main")
+	if envErr != nil {
+		slog.Info("Arquivo .env não encontrado, usando variáveis de ambiente do sistema", "component", "main")
+	}
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		slog.Error("Erro ao iniciar tracing", "component", "main", "error", err.Error())
+		os.Exit(1)
 	}
 
 	port := os.Getenv("PORT")
@@ -25,37 +36,107 @@ func main() {
 		port = "8001"
 	}
 
+	if err := LoadTenants(); err != nil {
+		slog.Error("Erro ao carregar tenants", "component", "main", "error", err.Error())
+		os.Exit(1)
+	}
+
+	if err := validateEventFilter(getCallbackEvents()); err != nil {
+		slog.Error("CALLBACK_EVENTS inválido", "component", "main", "error", err.Error())
+		os.Exit(1)
+	}
+
+	auth, err := NewAuthenticator()
+	if err != nil {
+		slog.Error("Erro ao configurar autenticação", "component", "main", "error", err.Error())
+		os.Exit(1)
+	}
+
 	queue := NewJobQueue()
+	registerQueueMetrics(queue)
 	handler := NewHandler(queue)
 
+	// health, ready e metrics ficam abertos para os probes do ECS/ALB e o
+	// Prometheus; o restante exige credencial com o escopo da operação.
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/hls/convert", handler.HandleConvert)
+	mux.HandleFunc("/api/hls/convert", auth.Require(ScopeSubmit, handler.HandleConvert))
+	mux.HandleFunc("/api/hls/jobs", auth.Require(ScopeRead, handler.HandleListJobs))
+	mux.HandleFunc("/api/hls/batch", auth.Require(ScopeSubmit, handler.HandleBatch))
+	mux.HandleFunc("/api/hls/batch/", auth.Require(ScopeRead, handler.HandleBatchStatus))
+	mux.HandleFunc("/api/hls/media/", auth.Require(ScopeDelete, handler.HandleDeleteMedia))
 	mux.HandleFunc("/api/hls/health", handler.HandleHealth)
+	mux.HandleFunc("/api/hls/ready", handler.HandleReady)
+	mux.Handle("/metrics", metricsHandler())
 	mux.HandleFunc("/api/hls/", func(w http.ResponseWriter, r *http.Request) {
-		// Route DELETE /api/hls/{conversion_id}
-		if r.Method == http.MethodDelete {
-			path := strings.TrimPrefix(r.URL.Path, "/api/hls/")
-			if path != "" && path != "convert" && path != "health" {
-				handler.HandleCancel(w, r)
-				return
-			}
+		path := strings.TrimPrefix(r.URL.Path, "/api/hls/")
+		if path == "" || path == "convert" || path == "health" || path == "ready" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case strings.HasSuffix(path, "/priority") && r.Method == http.MethodPost:
+			// POST /api/hls/{conversion_id}/priority
+			auth.Require(ScopeAdmin, handler.HandleSetPriority)(w, r)
+		case strings.HasSuffix(path, "/retry") && r.Method == http.MethodPost:
+			// POST /api/hls/{conversion_id}/retry
+			auth.Require(ScopeSubmit, handler.HandleRetry)(w, r)
+		case strings.HasSuffix(path, "/pause") && r.Method == http.MethodPost:
+			// POST /api/hls/{conversion_id}/pause
+			auth.Require(ScopeCancel, handler.HandlePause)(w, r)
+		case strings.HasSuffix(path, "/resume") && r.Method == http.MethodPost:
+			// POST /api/hls/{conversion_id}/resume
+			auth.Require(ScopeCancel, handler.HandleResume)(w, r)
+		case strings.Contains(strings.TrimSuffix(path, "/"), "/"):
+			http.NotFound(w, r)
+		case r.Method == http.MethodDelete:
+			// DELETE /api/hls/{conversion_id}
+			auth.Require(ScopeCancel, handler.HandleCancel)(w, r)
+		case r.Method == http.MethodGet:
+			// GET /api/hls/{conversion_id}
+			auth.Require(ScopeRead, handler.HandleStatus)(w, r)
+		default:
+			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		}
-		http.NotFound(w, r)
 	})
 
-	// Graceful shutdown
+	if err := queue.RestorePending(); err != nil {
+		slog.Error("Erro ao restaurar jobs pendentes", "component", "main", "error", err.Error())
+	}
+
+	addr := ":" + port
+	server := &http.Server{Addr: addr, Handler: mux}
+
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		sig := <-sigChan
-		log.Printf("[MAIN] Sinal recebido: %v. Encerrando...", sig)
-		queue.Shutdown()
-		os.Exit(0)
+		slog.Info("Servidor HTTP escutando", "component", "main", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Erro ao iniciar servidor", "component", "main", "error", err.Error())
+			os.Exit(1)
+		}
 	}()
 
-	addr := ":" + port
-	log.Printf("[MAIN] Servidor HTTP escutando em %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("[MAIN] Erro ao iniciar servidor: %v", err)
+	// Graceful shutdown: para de aceitar requisições, espera o job em
+	// execução até SHUTDOWN_DRAIN_TIMEOUT_SECONDS e devolve o restante da fila.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigChan
+	slog.Info("Sinal recebido, encerrando", "component", "main", "signal", sig.String())
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := server.Shutdown(httpCtx); err != nil {
+		slog.Error("Erro ao encerrar servidor HTTP", "component", "main", "error", err.Error())
+	}
+	cancelHTTP()
+
+	drainTimeout := time.Duration(getEnvInt("SHUTDOWN_DRAIN_TIMEOUT_SECONDS", 25)) * time.Second
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	queue.Shutdown(drainCtx)
+	cancelDrain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Erro ao finalizar tracing", "component", "main", "error", err.Error())
 	}
+	cancel()
+	slog.Info("Serviço encerrado", "component", "main")
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricEncodeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hls_encode_duration_seconds",
+		Help:    "Tempo de execução do ffmpeg por qualidade.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 12),
+	}, []string{"quality"})
+
+	metricEncodeRealtimeFactor = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hls_encode_realtime_factor",
+		Help:    "Duração da mídia dividida pelo tempo de encode (>1 é mais rápido que tempo real).",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64},
+	}, []string{"quality"})
+
+	metricBytesDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_storage_downloaded_bytes_total",
+		Help: "Bytes baixados por backend de storage.",
+	}, []string{"backend"})
+
+	metricBytesUploaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_storage_uploaded_bytes_total",
+		Help: "Bytes enviados por backend de storage.",
+	}, []string{"backend"})
+
+	metricCallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_callbacks_total",
+		Help: "Callbacks enviados, por tipo de evento e resultado da entrega.",
+	}, []string{"status", "result"})
+
+	metricSubmissionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_submissions_rejected_total",
+		Help: "Conversões recusadas na submissão, por motivo.",
+	}, []string{"reason"})
+
+	metricRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_retries_total",
+		Help: "Novas tentativas automáticas após falha transitória, por fase.",
+	}, []string{"phase"})
+
+	metricFFmpegExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hls_ffmpeg_exits_total",
+		Help: "Execuções do ffmpeg por código de saída (-1 quando morto por sinal).",
+	}, []string{"code"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricEncodeDuration,
+		metricEncodeRealtimeFactor,
+		metricBytesDownloaded,
+		metricBytesUploaded,
+		metricCallbacks,
+		metricSubmissionsRejected,
+		metricFFmpegExits,
+		metricRetries,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hls_temp_disk_used_bytes",
+			Help: "Bytes ocupados em TEMP_DIR.",
+		}, func() float64 { return float64(dirSize(getTempDir())) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hls_temp_disk_free_bytes",
+			Help: "Bytes livres no sistema de arquivos de TEMP_DIR.",
+		}, func() float64 {
+			free, _, err := diskSpace(getTempDir())
+			if err != nil {
+				return -1
+			}
+			return float64(free)
+		}),
+	)
+}
+
+// registerQueueMetrics expõe a profundidade da fila e os jobs ativos,
+// usados para alertas de backlog e autoscaling.
+func registerQueueMetrics(q *JobQueue) {
+	for _, name := range q.QueueNames() {
+		name := name
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "hls_queue_depth",
+			Help:        "Jobs aguardando, por fila nomeada.",
+			ConstLabels: prometheus.Labels{"queue": name},
+		}, func() float64 { return float64(q.QueueDepth(name)) }))
+	}
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "hls_active_jobs",
+		Help: "Jobs na fila ou em processamento.",
+	}, func() float64 { return float64(q.ActiveCount()) }))
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+func observeEncode(quality string, seconds float64, mediaDuration int) {
+	metricEncodeDuration.WithLabelValues(quality).Observe(seconds)
+	if mediaDuration > 0 && seconds > 0 {
+		metricEncodeRealtimeFactor.WithLabelValues(quality).Observe(float64(mediaDuration) / seconds)
+	}
+}
+
+func observeFFmpegExit(code int) {
+	metricFFmpegExits.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+func observeRetry(phase string) {
+	metricRetries.WithLabelValues(phase).Inc()
+}
+
+func observeCallback(status string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	metricCallbacks.WithLabelValues(status, result).Inc()
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type QualitySettings struct {
@@ -43,26 +46,40 @@ var QualityResolution = map[string]string{
 }
 
 type WatermarkConfig struct {
-	Enabled   bool    `json:"enabled"`
-	S3Path    string  `json:"s3_path"`
-	Position  string  `json:"position"`
-	Opacity   float64 `json:"opacity"`
-	Size      int     `json:"size"`
+	Enabled  bool    `json:"enabled"`
+	S3Path   string  `json:"s3_path"`
+	Position string  `json:"position"`
+	Opacity  float64 `json:"opacity"`
+	Size     int     `json:"size"`
 }
 
 type ConvertRequest struct {
-	MediaFileID   int              `json:"media_file_id"`
-	Title         string           `json:"title"`
-	S3Path        string           `json:"s3_path"`
-	Width         int              `json:"width"`
-	Height        int              `json:"height"`
-	Duration      int              `json:"duration"`
-	FPS           int              `json:"fps"`
-	Qualities     []string         `json:"qualities"`
-	CloudfrontURL string           `json:"cloudfront_url"`
-	CallbackURL   string           `json:"callback_url"`
-	GOPSize       int              `json:"gop_size"`
-	Watermark     *WatermarkConfig `json:"watermark,omitempty"`
+	MediaFileID int    `json:"media_file_id"`
+	Title       string `json:"title"`
+	S3Path      string `json:"s3_path"`
+	SourceURL   string `json:"source_url,omitempty"`
+	InputMode   string `json:"input_mode,omitempty"`
+	OnConflict  string `json:"on_conflict,omitempty"`
+	Queue       string `json:"queue,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+
+	// BatchID é preenchido pelo serviço nos jobs criados por POST /api/hls/batch.
+	BatchID string `json:"batch_id,omitempty"`
+
+	// Templates das chaves de saída; veja OutputLayout.
+	RenditionTemplate string           `json:"rendition_template,omitempty"`
+	MasterTemplate    string           `json:"master_template,omitempty"`
+	Width             int              `json:"width"`
+	Height            int              `json:"height"`
+	Duration          int              `json:"duration"`
+	FPS               int              `json:"fps"`
+	Qualities         []string         `json:"qualities"`
+	CloudfrontURL     string           `json:"cloudfront_url"`
+	CallbackURL       string           `json:"callback_url"`
+	GOPSize           int              `json:"gop_size"`
+	Watermark         *WatermarkConfig `json:"watermark,omitempty"`
 }
 
 type ConvertResponse struct {
@@ -81,17 +98,118 @@ type CallbackPayload struct {
 	Status       string `json:"status"`
 	S3Path       string `json:"s3_path,omitempty"`
 	ErrorMessage string `json:"error_message,omitempty"`
+
+	// ErrorKind classifica falhas: "transient" (vale repetir, por exemplo com
+	// POST /api/hls/{conversion_id}/retry) ou "permanent" (entrada inválida).
+	ErrorKind string `json:"error_kind,omitempty"`
 }
 
 type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+const (
+	CheckOK     = "ok"
+	CheckFailed = "failed"
+)
+
+type CheckResult struct {
+	Status string         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+	Detail map[string]any `json:"detail,omitempty"`
+}
+
+type ReadyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const (
+	JobStateQueued    = "queued"
+	JobStateRunning   = "running"
+	JobStateCancelled = "cancelled"
+)
+
+// JobStatus é o estado de uma conversão ativa. Position conta a partir de 1
+// e considera os pesos de todas as filas; QueuePosition é a posição dentro
+// da fila nomeada. Ambas são zero quando o job não está aguardando.
+type JobStatus struct {
+	ConversionID       string    `json:"conversion_id"`
+	MediaFileID        int       `json:"media_file_id"`
+	Tenant             string    `json:"tenant,omitempty"`
+	State              string    `json:"state"`
+	Queue              string    `json:"queue"`
+	Priority           int       `json:"priority"`
+	Position           int       `json:"position,omitempty"`
+	QueuePosition      int       `json:"queue_position,omitempty"`
+	EnqueuedAt         time.Time `json:"enqueued_at"`
+	CompletedQualities []string  `json:"completed_qualities"`
+	FailedQualities    []string  `json:"failed_qualities"`
+}
+
+type PriorityRequest struct {
+	Priority *int `json:"priority"`
+}
+
+// RetryRequest é o corpo opcional de POST /api/hls/{conversion_id}/retry.
+// Sem qualities, todas as qualidades com falha ou não processadas são
+// repetidas.
+type RetryRequest struct {
+	Qualities []string `json:"qualities"`
+}
+
 type ConversionJob struct {
 	ID                 string
 	Request            ConvertRequest
 	Cancel             context.CancelFunc
 	Ctx                context.Context
 	CompletedQualities []string
+	FailedQualities    []string
+	EnqueuedAt         time.Time
+	StartedAt          time.Time
+	FinishedAt         time.Time
 	Mu                 sync.Mutex
+
+	// Tempo por fase e resultado por qualidade, para o histórico. Protegidos
+	// por Mu; veja recordPhase e recordOutcome.
+	PhaseSeconds map[string]float64
+	Outcomes     map[string]*QualityOutcome
+
+	// Published indica que a master playlist foi gravada com as renditions
+	// do job. Protegido por Mu.
+	Published bool
+
+	// seq desempata jobs de mesma prioridade pela ordem de chegada.
+	seq uint64
+
+	// remaining restringe as qualidades processadas; vazio processa todas as
+	// do request. O job retomado de uma pausa com release mantém o request
+	// original, que vale para a cota, o histórico e o retry, e processa só
+	// estas. Definido antes de o job entrar na fila.
+	remaining []string
+
+	// Estado de pausa, protegido por Mu; veja pause.go. resumed só existe
+	// enquanto o job está suspenso e é fechado no resume.
+	process     *os.Process
+	resumed     chan struct{}
+	pausedAt    time.Time
+	pausedTotal time.Duration
+
+	// Interrupted indica que o job foi cancelado pelo shutdown, e não pelo
+	// usuário: as qualidades restantes são devolvidas em vez de falharem.
+	Interrupted atomic.Bool
+
+	// Superseded indica que o job foi cancelado por uma nova submissão da
+	// mesma mídia (on_conflict=supersede): as qualidades restantes não geram
+	// callbacks de falha, pois o novo job vai reportá-las.
+	Superseded atomic.Bool
+
+	// Parked indica que o job foi interrompido por um pause com release: a
+	// fila o retém com as qualidades restantes até o resume.
+	Parked atomic.Bool
+
+	// resumeOnPark indica que o resume chegou enquanto o job pausado com
+	// release ainda terminava a qualidade em andamento: park devolve as
+	// qualidades restantes direto para a fila. Protegido por q.mu.
+	resumeOnPark bool
 }
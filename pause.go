@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+const JobStatePaused = "paused"
+
+var (
+	ErrJobPaused    = errors.New("conversão já pausada")
+	ErrJobNotPaused = errors.New("conversão não está pausada")
+)
+
+// getPauseReleaseWorker indica se, por padrão, pausar um job em processamento
+// libera o worker (PAUSE_RELEASE_WORKER) em vez de só suspender o ffmpeg.
+func getPauseReleaseWorker() bool {
+	return getEnvBool("PAUSE_RELEASE_WORKER", false)
+}
+
+// Pause pausa a conversão:
+//   - na fila, o job sai da fila e fica retido até o resume;
+//   - em processamento, o ffmpeg recebe SIGSTOP e o job espera o resume
+//     ocupando o worker. O que estiver em download ou upload termina, e o job
+//     para antes da próxima qualidade;
+//   - em processamento com release, o ffmpeg é encerrado e o worker liberado.
+//     As qualidades concluídas são mantidas; a qualidade em andamento é
+//     refeita do início no resume.
+//
+// Sem suporte a SIGSTOP na plataforma, jobs em processamento são sempre
+// pausados com release.
+func (q *JobQueue) Pause(conversionID string, release bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.active[conversionID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	// Resume e pause de novo antes de o worker devolver o job: continua retido.
+	if job.Parked.Load() && job.resumeOnPark {
+		job.resumeOnPark = false
+		jobLogger(job).Info("Job pausado, liberando o worker", "component", "queue")
+		sendJobEvent(job, EventJobPaused, JobPausedData{Mode: PauseReleased})
+		return nil
+	}
+	if q.isPausedLocked(job) {
+		return ErrJobPaused
+	}
+	if job.Ctx.Err() != nil {
+		return ErrJobNotFound
+	}
+
+	logger := jobLogger(job).With("component", "queue")
+	if job != q.current {
+		if q.byName[job.Request.Queue].remove(job) {
+			q.queued--
+		}
+		q.paused[job.ID] = job
+		job.Mu.Lock()
+		job.pausedAt = time.Now()
+		job.Mu.Unlock()
+		logger.Info("Job pausado na fila")
+		sendJobEvent(job, EventJobPaused, JobPausedData{Mode: PauseHeld})
+		return nil
+	}
+
+	if !release {
+		err := job.suspend()
+		if err == nil {
+			logger.Info("Job pausado, ffmpeg suspenso")
+			sendJobEvent(job, EventJobPaused, JobPausedData{Mode: PauseSuspended})
+			return nil
+		}
+		logger.Warn("Não foi possível suspender o ffmpeg, liberando o worker", "error", err.Error())
+	}
+
+	// O worker devolve o job em park, quando processJob retornar.
+	job.Parked.Store(true)
+	job.Interrupted.Store(true)
+	job.Cancel()
+	logger.Info("Job pausado, liberando o worker")
+	sendJobEvent(job, EventJobPaused, JobPausedData{Mode: PauseReleased})
+	return nil
+}
+
+// Resume retoma uma conversão pausada: jobs retidos voltam para a fila na
+// posição que tinham e jobs suspensos continuam o ffmpeg de onde pararam. Um
+// job pausado com release que o worker ainda não devolveu volta para a fila
+// assim que for devolvido.
+func (q *JobQueue) Resume(conversionID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.active[conversionID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if _, held := q.paused[job.ID]; held {
+		q.unholdLocked(job)
+		jobLogger(job).Info("Job retomado", "component", "queue")
+		sendJobEvent(job, EventJobResumed, nil)
+		return nil
+	}
+	if job.Parked.Load() {
+		if job.resumeOnPark {
+			return ErrJobNotPaused
+		}
+		job.resumeOnPark = true
+		jobLogger(job).Info("Job retomado, volta para a fila ao ser liberado pelo worker", "component", "queue")
+		sendJobEvent(job, EventJobResumed, nil)
+		return nil
+	}
+	if !job.isSuspended() {
+		return ErrJobNotPaused
+	}
+	if err := job.resume(); err != nil {
+		return err
+	}
+	jobLogger(job).Info("Job retomado, ffmpeg continuando", "component", "queue")
+	sendJobEvent(job, EventJobResumed, nil)
+	return nil
+}
+
+// unholdLocked devolve à fila um job retido por Pause. Deve ser chamado com
+// q.mu travado.
+func (q *JobQueue) unholdLocked(job *ConversionJob) {
+	job.Mu.Lock()
+	paused := time.Since(job.pausedAt)
+	job.Mu.Unlock()
+	job.recordPhase("", PhasePaused, paused)
+
+	delete(q.paused, job.ID)
+	q.byName[job.Request.Queue].insert(job)
+	q.queued++
+	q.signal()
+}
+
+// enqueuePaused enfileira um job já retido, como os jobs pausados restaurados
+// de QUEUE_STATE_FILE.
+func (q *JobQueue) enqueuePaused(job *ConversionJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	existing, err := q.insertLocked(job)
+	if err != nil {
+		return err
+	}
+	q.byName[job.Request.Queue].remove(job)
+	q.queued--
+	q.paused[job.ID] = job
+	job.pausedAt = time.Now()
+	q.admitted(job, existing)
+	return nil
+}
+
+// heldJobs retorna os jobs retidos, na ordem de chegada.
+func (q *JobQueue) heldJobs() []*ConversionJob {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	jobs := make([]*ConversionJob, 0, len(q.paused))
+	for _, job := range q.paused {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].seq < jobs[j].seq })
+	return jobs
+}
+
+// park é chamado pelo worker quando um job pausado com release termina com
+// qualidades restantes: elas passam para um novo job com o mesmo
+// conversion_id, retido até o resume (ou já na fila, se o resume chegou antes).
+func (q *JobQueue) park(job *ConversionJob) {
+	next := newConversionJob(context.WithoutCancel(job.Ctx), job.ID, job.Request)
+	next.remaining = remainingQualities(job)
+
+	job.Mu.Lock()
+	next.EnqueuedAt = job.EnqueuedAt
+	next.StartedAt = job.StartedAt
+	next.Published = job.Published
+	next.CompletedQualities = append([]string{}, job.CompletedQualities...)
+	next.FailedQualities = append([]string{}, job.FailedQualities...)
+	next.PhaseSeconds = make(map[string]float64, len(job.PhaseSeconds))
+	for phase, s := range job.PhaseSeconds {
+		next.PhaseSeconds[phase] = s
+	}
+	next.Outcomes = make(map[string]*QualityOutcome, len(job.Outcomes))
+	for quality, o := range job.Outcomes {
+		// A qualidade interrompida volta a ser pendente.
+		if o.Status != QualityPending {
+			c := *o
+			next.Outcomes[quality] = &c
+		}
+	}
+	next.pausedAt = time.Now()
+	job.Mu.Unlock()
+
+	q.mu.Lock()
+	next.seq = job.seq
+	q.active[job.ID] = next
+	q.paused[job.ID] = next
+	resumed := job.resumeOnPark
+	if resumed {
+		q.unholdLocked(next)
+	}
+	q.mu.Unlock()
+	if resumed {
+		jobLogger(next).Info("Job devolvido à fila", "component", "queue", "qualities", next.remaining)
+		return
+	}
+	jobLogger(next).Info("Job retido até o resume", "component", "queue", "qualities", next.remaining)
+}
+
+// isPausedLocked indica se o job está retido, sendo retido ou suspenso. Deve ser chamado
+// com q.mu travado.
+func (q *JobQueue) isPausedLocked(job *ConversionJob) bool {
+	_, held := q.paused[job.ID]
+	return held || (job.Parked.Load() && !job.resumeOnPark) || job.isSuspended()
+}
+
+func (j *ConversionJob) isSuspended() bool {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	return j.resumed != nil
+}
+
+// suspend suspende o ffmpeg em execução (se houver) e fecha a passagem para
+// a próxima qualidade até resume.
+func (j *ConversionJob) suspend() error {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	if j.process != nil {
+		if err := suspendProcess(j.process); err != nil {
+			return err
+		}
+	}
+	j.resumed = make(chan struct{})
+	j.pausedAt = time.Now()
+	return nil
+}
+
+func (j *ConversionJob) resume() error {
+	j.Mu.Lock()
+	if j.process != nil {
+		if err := resumeProcess(j.process); err != nil {
+			j.Mu.Unlock()
+			return err
+		}
+	}
+	close(j.resumed)
+	j.resumed = nil
+	d := time.Since(j.pausedAt)
+	j.pausedTotal += d
+	j.Mu.Unlock()
+
+	j.recordPhase("", PhasePaused, d)
+	return nil
+}
+
+// attachProcess registra o ffmpeg em execução, para que Pause possa
+// suspendê-lo. Um processo iniciado durante a pausa já nasce suspenso.
+func (j *ConversionJob) attachProcess(p *os.Process) {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	j.process = p
+	if p != nil && j.resumed != nil {
+		suspendProcess(p)
+	}
+}
+
+// waitWhilePaused bloqueia enquanto o job estiver suspenso.
+func (j *ConversionJob) waitWhilePaused(ctx context.Context) {
+	j.Mu.Lock()
+	resumed := j.resumed
+	j.Mu.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-resumed:
+	}
+}
+
+// pausedDuration é o tempo total em que o job ficou suspenso, descontado das
+// medições de encode.
+func (j *ConversionJob) pausedDuration() time.Duration {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	d := j.pausedTotal
+	if j.resumed != nil {
+		d += time.Since(j.pausedAt)
+	}
+	return d
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errSuspendUnsupported = errors.New("suspensão de processos não suportada nesta plataforma")
+
+func suspendProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
+
+func resumeProcess(p *os.Process) error {
+	return errSuspendUnsupported
+}
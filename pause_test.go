@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// releasedJob simula um job pausado com release depois de concluir 360p e
+// falhar 480p, devolvido pelo worker em park e retomado. Retorna o job que
+// volta para a fila.
+func releasedJob(t *testing.T, q *JobQueue) *ConversionJob {
+	t.Helper()
+	req := ConvertRequest{MediaFileID: 1, Tenant: "acme", Duration: 600, Qualities: []string{"360p", "480p", "720p"}}
+	if err := tenants.ReserveMinutes(req); err != nil {
+		t.Fatal(err)
+	}
+	job := newConversionJob(context.Background(), "conv-1", req)
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+	if q.dequeue() != job {
+		t.Fatal("dequeue não retornou o job")
+	}
+	q.mu.Lock()
+	q.current = job
+	q.mu.Unlock()
+
+	job.CompletedQualities = []string{"360p"}
+	job.FailedQualities = []string{"480p"}
+	job.recordOutcome("360p", QualityCompleted, "hls/1/360p/master.m3u8", nil)
+	job.recordOutcome("480p", QualityFailed, "", errors.New("falhou"))
+	if err := q.Pause("conv-1", true); err != nil {
+		t.Fatal(err)
+	}
+	q.park(job)
+	if err := q.Resume("conv-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := q.dequeue()
+	if next == nil || next == job {
+		t.Fatal("job retomado não voltou para a fila")
+	}
+	return next
+}
+
+func usedMinutes(tenant string) int {
+	tenants.usage.mu.Lock()
+	defer tenants.usage.mu.Unlock()
+	return tenants.usage.minutes[tenant][currentMonth()]
+}
+
+func TestParkKeepsRequestAndReservation(t *testing.T) {
+	q := newTestQueue(t)
+	withTenants(t, map[string]*Tenant{"acme": {MonthlyMinutes: 1000}})
+
+	next := releasedJob(t, q)
+	if got := strings.Join(next.Request.Qualities, ","); got != "360p,480p,720p" {
+		t.Errorf("request do job retomado = %s, want o original", got)
+	}
+	if got := strings.Join(next.qualitiesToProcess(), ","); got != "720p" {
+		t.Errorf("qualidades a processar = %s, want 720p", got)
+	}
+
+	// 3 qualidades de 10 minutos reservadas; 360p e 720p codificadas.
+	if got := usedMinutes("acme"); got != 30 {
+		t.Fatalf("reserva = %d, want 30", got)
+	}
+	next.Mu.Lock()
+	next.CompletedQualities = append(next.CompletedQualities, "720p")
+	next.Mu.Unlock()
+	next.recordOutcome("720p", QualityCompleted, "hls/1/720p/master.m3u8", nil)
+	q.finish(next)
+	if got := usedMinutes("acme"); got != 20 {
+		t.Errorf("consumo acertado = %d, want 20", got)
+	}
+
+	rec, ok := q.Record("conv-1")
+	if !ok {
+		t.Fatal("job fora do histórico")
+	}
+	var statuses []string
+	for _, o := range rec.Qualities {
+		statuses = append(statuses, o.Quality+":"+o.Status)
+	}
+	if got, want := strings.Join(statuses, ","), "360p:completed,480p:failed,720p:completed"; got != want {
+		t.Errorf("qualidades no histórico = %s, want %s", got, want)
+	}
+}
+
+func TestRetryAfterReleasedPause(t *testing.T) {
+	q := newTestQueue(t)
+	withTenants(t, map[string]*Tenant{"acme": {MonthlyMinutes: 1000}})
+
+	next := releasedJob(t, q)
+	next.Mu.Lock()
+	next.CompletedQualities = append(next.CompletedQualities, "720p")
+	next.Mu.Unlock()
+	next.recordOutcome("720p", QualityCompleted, "hls/1/720p/master.m3u8", nil)
+	q.finish(next)
+
+	// 480p falhou antes da pausa e continua disponível para o retry.
+	retried, err := q.Retry(context.Background(), "conv-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(retried.Request.Qualities, ","); got != "480p" {
+		t.Errorf("qualidades do retry = %s, want 480p", got)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modos de publicação das renditions.
+//
+// in_place grava sempre no mesmo prefixo e atualiza a master playlist a cada
+// qualidade concluída. versioned grava cada conversão em um prefixo próprio
+// (com {conversion_id}) e só troca a master playlist quando todas as
+// qualidades terminam, para que uma reconversão nunca altere segmentos que
+// estão sendo assistidos.
+const (
+	PublishInPlace   = "in_place"
+	PublishVersioned = "versioned"
+)
+
+const defaultVersionedRenditionTemplate = "hls/{media_id}/v/{conversion_id}/{quality}"
+
+func publishMode(req ConvertRequest) string {
+	if t := tenants.Lookup(req.Tenant); t != nil && t.PublishMode != "" {
+		return t.PublishMode
+	}
+	return envOrDefault("PUBLISH_MODE", PublishInPlace)
+}
+
+func getPublishGCGrace() time.Duration {
+	return time.Duration(getEnvInt("PUBLISH_GC_GRACE_MINUTES", 1440)) * time.Minute
+}
+
+// publishManifest é o ponteiro da versão publicada, gravado ao lado da
+// master playlist (master.m3u8 -> master.json). Também guarda as versões
+// substituídas até que o período de carência expire e elas sejam removidas.
+type publishManifest struct {
+	MediaFileID int               `json:"media_file_id"`
+	Version     string            `json:"version"`
+	PublishedAt time.Time         `json:"published_at"`
+	Master      string            `json:"master"`
+	Renditions  map[string]string `json:"renditions"`
+	Prefixes    []string          `json:"prefixes"`
+	Retired     []retiredVersion  `json:"retired,omitempty"`
+}
+
+type retiredVersion struct {
+	Version   string    `json:"version"`
+	Prefixes  []string  `json:"prefixes"`
+	RetiredAt time.Time `json:"retired_at"`
+}
+
+func manifestKey(layout OutputLayout) string {
+	return strings.TrimSuffix(layout.MasterPlaylist(), ".m3u8") + ".json"
+}
+
+// publishMu serializa a leitura-e-escrita dos manifests entre publicações e
+// coletas agendadas.
+var publishMu sync.Mutex
+
+// publishVersion troca a versão publicada da mídia: grava a master playlist
+// apontando para as renditions do job (a troca em si, um único PUT) e então
+// o manifest, aposentando a versão anterior. No modo repair as renditions da
+// versão anterior que o job não recodificou são mantidas.
+func publishVersion(ctx context.Context, output Storage, tempDir string, layout OutputLayout, job *ConversionJob, qualities []string) error {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	logger := ctxLogger(ctx, "publish").With("phase", PhasePlaylist)
+	key := manifestKey(layout)
+
+	prev, err := readManifest(ctx, output, tempDir, key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	manifest := publishManifest{
+		MediaFileID: job.Request.MediaFileID,
+		Version:     job.ID,
+		PublishedAt: now,
+		Master:      layout.MasterPlaylist(),
+		Renditions:  make(map[string]string),
+	}
+	for _, q := range qualities {
+		manifest.Renditions[q] = layout.RenditionPlaylist(q)
+		manifest.Prefixes = append(manifest.Prefixes, layout.RenditionDir(q))
+	}
+
+	// No modo repair as qualidades não recodificadas continuam nos prefixos
+	// da versão anterior, que deixam de ser aposentados.
+	if prev != nil && job.Request.Mode == ConvertModeRepair {
+		for q, key := range prev.Renditions {
+			if _, ok := manifest.Renditions[q]; !ok {
+				manifest.Renditions[q] = key
+				manifest.Prefixes = append(manifest.Prefixes, path.Dir(key))
+			}
+		}
+	}
+
+	if err := uploadMasterPlaylist(ctx, output, tempDir, manifest.Master, manifest.Renditions); err != nil {
+		return err
+	}
+
+	if prev != nil {
+		manifest.Retired = prev.Retired
+		var retired []string
+		for _, prefix := range prev.Prefixes {
+			if !containsString(manifest.Prefixes, prefix) {
+				retired = append(retired, prefix)
+			}
+		}
+		if prev.Version != "" && prev.Version != job.ID && len(retired) > 0 {
+			manifest.Retired = append(manifest.Retired, retiredVersion{Version: prev.Version, Prefixes: retired, RetiredAt: now})
+		}
+	}
+
+	manifest.Retired = collectRetired(ctx, output, manifest.Retired)
+	if err := writeManifest(ctx, output, tempDir, key, &manifest); err != nil {
+		return err
+	}
+	logger.Info("Versão publicada", "version", job.ID, "master", manifest.Master, "retired_pending", len(manifest.Retired))
+
+	scheduleGC(job.Request, layout, manifest.Retired)
+	return nil
+}
+
+// collectRetired remove as versões aposentadas há mais que o período de
+// carência e retorna as que ainda devem ser mantidas.
+func collectRetired(ctx context.Context, output Storage, retired []retiredVersion) []retiredVersion {
+	logger := ctxLogger(ctx, "publish").With("phase", PhaseCleanup)
+	grace := getPublishGCGrace()
+
+	var keep []retiredVersion
+	for _, r := range retired {
+		if time.Since(r.RetiredAt) < grace {
+			keep = append(keep, r)
+			continue
+		}
+		var failed bool
+		for _, prefix := range r.Prefixes {
+			if _, err := output.DeletePrefix(ctx, prefix); err != nil {
+				logger.Error("Erro ao remover versão antiga", "version", r.Version, "prefix", prefix, "error", err.Error())
+				failed = true
+			}
+		}
+		if failed {
+			keep = append(keep, r)
+			continue
+		}
+		logger.Info("Versão antiga removida", "version", r.Version)
+	}
+	return keep
+}
+
+// scheduleGC agenda a coleta da próxima versão aposentada que vencer. O
+// timer não sobrevive a restarts; nesse caso a coleta acontece na próxima
+// publicação da mídia.
+func scheduleGC(req ConvertRequest, layout OutputLayout, retired []retiredVersion) {
+	if len(retired) == 0 {
+		return
+	}
+	next := retired[0].RetiredAt
+	for _, r := range retired[1:] {
+		if r.RetiredAt.Before(next) {
+			next = r.RetiredAt
+		}
+	}
+
+	time.AfterFunc(time.Until(next.Add(getPublishGCGrace())), func() {
+		logger := ctxLogger(context.Background(), "publish").With("media_file_id", req.MediaFileID, "phase", PhaseCleanup)
+		if err := runScheduledGC(req, layout); err != nil {
+			logger.Error("Erro na coleta de versões antigas", "error", err.Error())
+		}
+	})
+}
+
+func runScheduledGC(req ConvertRequest, layout OutputLayout) error {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, output, err := jobStorages(req)
+	if err != nil {
+		return err
+	}
+	tempDir, err := os.MkdirTemp(getTempDir(), "gc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	key := manifestKey(layout)
+	manifest, err := readManifest(ctx, output, tempDir, key)
+	if err != nil || manifest == nil {
+		return err
+	}
+
+	before := len(manifest.Retired)
+	manifest.Retired = collectRetired(ctx, output, manifest.Retired)
+	if len(manifest.Retired) == before {
+		return nil
+	}
+	if err := writeManifest(ctx, output, tempDir, key, manifest); err != nil {
+		return err
+	}
+	scheduleGC(req, layout, manifest.Retired)
+	return nil
+}
+
+// readManifest retorna nil, sem erro, quando a mídia ainda não foi publicada.
+func readManifest(ctx context.Context, output Storage, tempDir, key string) (*publishManifest, error) {
+	exists, err := output.Exists(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar manifest %s: %w", key, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	localPath := filepath.Join(tempDir, "manifest-previous.json")
+	if err := output.Download(ctx, key, localPath); err != nil {
+		return nil, fmt.Errorf("erro ao baixar manifest %s: %w", key, err)
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, err
+	}
+	var m publishManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest %s inválido: %w", key, err)
+	}
+	return &m, nil
+}
+
+func writeManifest(ctx context.Context, output Storage, tempDir, key string, m *publishManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	localPath := filepath.Join(tempDir, "manifest.json")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("erro ao escrever manifest: %w", err)
+	}
+	if err := output.Upload(ctx, localPath, key); err != nil {
+		return fmt.Errorf("erro ao enviar manifest %s: %w", key, err)
+	}
+	return nil
+}
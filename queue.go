@@ -1,44 +1,293 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
 	"sync"
+	"time"
 )
 
+var (
+	ErrQueueClosed  = errors.New("fila encerrada")
+	ErrQueueFull    = errors.New("fila cheia")
+	ErrUnknownQueue = errors.New("fila desconhecida")
+	ErrJobNotFound  = errors.New("conversão não encontrada")
+	ErrJobNotQueued = errors.New("conversão já iniciada")
+	ErrJobActive    = errors.New("conversão ainda ativa")
+	ErrNoRetry      = errors.New("nenhuma qualidade pendente ou com falha para repetir")
+	ErrNoSourceURL  = errors.New("source_url da conversão não está mais disponível; envie uma nova conversão")
+)
+
+// DuplicateJobError indica que a mídia já tem um job ativo (na fila ou em
+// processamento) e a política de conflito não permite substituí-lo.
+type DuplicateJobError struct {
+	ConversionID string
+	Policy       string
+}
+
+func (e *DuplicateJobError) Error() string {
+	return "mídia já possui conversão ativa: " + e.ConversionID
+}
+
 type JobQueue struct {
-	jobs    chan *ConversionJob
+	queues  []*namedQueue
+	byName  map[string]*namedQueue
+	maxSize int
+	queued  int
+	ready   chan struct{}
 	active  map[string]*ConversionJob
+	current *ConversionJob
+	closed  bool
+	stop    chan struct{}
+	pending []pendingJob
+	seq     uint64
 	mu      sync.RWMutex
 	wg      sync.WaitGroup
+
+	// history guarda os jobs encerrados, para consulta e retries manuais.
+	history *JobHistory
+	batches *BatchStore
+
+	// paused são os jobs retidos por Pause, fora das filas mas ainda ativos.
+	paused map[string]*ConversionJob
+}
+
+func getQueueMaxSize() int {
+	if n := getEnvInt("QUEUE_MAX_SIZE", 100); n > 0 {
+		return n
+	}
+	return 100
 }
 
 func NewJobQueue() *JobQueue {
 	q := &JobQueue{
-		jobs:   make(chan *ConversionJob, 100),
-		active: make(map[string]*ConversionJob),
+		queues:  getQueueConfigs(),
+		byName:  make(map[string]*namedQueue),
+		maxSize: getQueueMaxSize(),
+		ready:   make(chan struct{}, 1),
+		active:  make(map[string]*ConversionJob),
+		history: NewJobHistory(),
+		batches: NewBatchStore(),
+		paused:  make(map[string]*ConversionJob),
+		stop:    make(chan struct{}),
+	}
+	for _, nq := range q.queues {
+		q.byName[nq.name] = nq
 	}
 	q.wg.Add(1)
 	go q.worker()
 	return q
 }
 
-func (q *JobQueue) Enqueue(job *ConversionJob) {
+// newConversionJob cria o job com um contexto próprio, cancelável pela fila.
+func newConversionJob(parent context.Context, id string, req ConvertRequest) *ConversionJob {
+	ctx, cancel := context.WithCancel(parent)
+	return &ConversionJob{
+		ID:      id,
+		Request: req,
+		Cancel:  cancel,
+		Ctx:     ctx,
+	}
+}
+
+// ResolveQueue retorna o nome da fila para o request: a informada em "queue"
+// ou a primeira de QUEUES. Retorna ErrUnknownQueue se a fila não existir.
+func (q *JobQueue) ResolveQueue(name string) (string, error) {
+	if name == "" {
+		return q.queues[0].name, nil
+	}
+	if _, ok := q.byName[name]; !ok {
+		return "", ErrUnknownQueue
+	}
+	return name, nil
+}
+
+// Enqueue nunca bloqueia: com a fila cheia retorna ErrQueueFull. Se a mesma
+// mídia já tiver um job ativo, a política de conflito do request decide:
+// supersede cancela o job anterior e enfileira o novo; reject e attach
+// retornam *DuplicateJobError com o job existente.
+func (q *JobQueue) Enqueue(job *ConversionJob) error {
 	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	existing, err := q.insertLocked(job)
+	if err != nil {
+		return err
+	}
+	q.admitted(job, existing)
+	return nil
+}
+
+// EnqueueBatch enfileira os jobs de um lote sob a mesma trava, retornando o
+// erro de cada um (nil se aceito). Com atomic, a primeira recusa desfaz as
+// inserções anteriores e todos os jobs ficam de fora.
+func (q *JobQueue) EnqueueBatch(jobs []*ConversionJob, atomic bool) []error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	errs := make([]error, len(jobs))
+	superseded := make([]*ConversionJob, len(jobs))
+	for i, job := range jobs {
+		superseded[i], errs[i] = q.insertLocked(job)
+		if errs[i] != nil && atomic {
+			for _, inserted := range jobs[:i] {
+				q.withdrawLocked(inserted)
+			}
+			return errs
+		}
+	}
+	for i, job := range jobs {
+		if errs[i] == nil {
+			q.admitted(job, superseded[i])
+		}
+	}
+	return errs
+}
+
+// insertLocked valida e insere o job na fila, retornando o job ativo da
+// mesma mídia que ele vai substituir. Deve ser chamado com q.mu travado.
+func (q *JobQueue) insertLocked(job *ConversionJob) (*ConversionJob, error) {
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	if _, ok := q.active[job.ID]; ok {
+		return nil, ErrJobActive
+	}
+
+	name, err := q.ResolveQueue(job.Request.Queue)
+	if err != nil {
+		return nil, err
+	}
+	job.Request.Queue = name
+
+	policy := getConflictPolicy(job.Request)
+	existing := q.activeForMedia(job.Request.Tenant, job.Request.MediaFileID)
+	if existing != nil && policy != ConflictSupersede {
+		return nil, &DuplicateJobError{ConversionID: existing.ID, Policy: policy}
+	}
+
+	// O job substituído deixa de contar para o limite do tenant.
+	if t := tenants.Lookup(job.Request.Tenant); t != nil && t.MaxConcurrentJobs > 0 {
+		if q.activeForTenant(t.Name, existing) >= t.MaxConcurrentJobs {
+			return nil, ErrTenantConcurrency
+		}
+	}
+
+	if q.queued >= q.maxSize {
+		return nil, ErrQueueFull
+	}
+	q.seq++
+	job.seq = q.seq
+	if job.EnqueuedAt.IsZero() {
+		job.EnqueuedAt = time.Now()
+	}
+	q.byName[name].insert(job)
+	q.queued++
 	q.active[job.ID] = job
-	q.mu.Unlock()
-	q.jobs <- job
-	log.Printf("[QUEUE] Job %s enfileirado (media_file_id=%d, qualidades=%v)", job.ID, job.Request.MediaFileID, job.Request.Qualities)
+	return existing, nil
 }
 
-func (q *JobQueue) Cancel(conversionID string) bool {
+// withdrawLocked desfaz insertLocked. Deve ser chamado com q.mu travado.
+func (q *JobQueue) withdrawLocked(job *ConversionJob) {
+	if q.byName[job.Request.Queue].remove(job) {
+		q.queued--
+	}
+	delete(q.active, job.ID)
+}
+
+// admitted acorda o worker e cancela o job substituído. Deve ser chamado com
+// q.mu travado.
+func (q *JobQueue) admitted(job, existing *ConversionJob) {
+	q.signal()
+
+	// O worker é único, então o job substituído termina (cancelado) antes de o
+	// novo começar a escrever em hls/{media_file_id}.
+	if existing != nil {
+		existing.Superseded.Store(true)
+		existing.Cancel()
+		// Um job pausado na fila volta para ela e termina como os demais
+		// cancelados.
+		if _, held := q.paused[existing.ID]; held {
+			q.unholdLocked(existing)
+		}
+		jobLogger(existing).Info("Job substituído por nova submissão", "component", "queue", "superseded_by", job.ID)
+	}
+
+	jobLogger(job).Info("Job enfileirado", "component", "queue", "phase", PhaseQueue,
+		"queue", job.Request.Queue, "priority", job.Request.Priority, "qualities", job.Request.Qualities)
+	sendJobEvent(job, EventJobQueued, JobQueuedData{Queue: job.Request.Queue, Priority: job.Request.Priority, Qualities: job.qualitiesToProcess()})
+}
+
+// signal acorda o worker sem bloquear. Deve ser chamado com q.mu travado.
+func (q *JobQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue retira o próximo job respeitando os pesos das filas e as
+// prioridades dentro de cada fila. Retorna nil se não houver jobs.
+func (q *JobQueue) dequeue() *ConversionJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	nq := nextQueue(q.queues)
+	if nq == nil {
+		return nil
+	}
+	job := nq.jobs[0]
+	nq.jobs = nq.jobs[1:]
+	q.queued--
+	return job
+}
+
+// activeForMedia retorna o job não cancelado da mídia do tenant, esteja ele
+// na fila ou em processamento. Deve ser chamado com q.mu travado.
+func (q *JobQueue) activeForMedia(tenant string, mediaFileID int) *ConversionJob {
+	for _, job := range q.active {
+		if job.Request.Tenant == tenant && job.Request.MediaFileID == mediaFileID && job.Ctx.Err() == nil {
+			return job
+		}
+	}
+	return nil
+}
+
+// HasActiveJob indica se a mídia do tenant tem um job na fila ou em
+// processamento.
+func (q *JobQueue) HasActiveJob(tenant string, mediaFileID int) bool {
 	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.activeForMedia(tenant, mediaFileID) != nil
+}
+
+// activeForTenant conta os jobs não cancelados do tenant, na fila ou em
+// processamento, ignorando except. Deve ser chamado com q.mu travado.
+func (q *JobQueue) activeForTenant(tenant string, except *ConversionJob) int {
+	n := 0
+	for _, job := range q.active {
+		if job != except && job.Request.Tenant == tenant && job.Ctx.Err() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *JobQueue) Cancel(conversionID string) bool {
+	q.mu.Lock()
 	job, exists := q.active[conversionID]
-	q.mu.RUnlock()
+	if exists {
+		job.Cancel()
+		if _, held := q.paused[job.ID]; held {
+			q.unholdLocked(job)
+		}
+	}
+	q.mu.Unlock()
 	if !exists {
 		return false
 	}
-	job.Cancel()
-	log.Printf("[QUEUE] Job %s cancelado", conversionID)
+	jobLogger(job).Info("Job cancelado", "component", "queue")
 	return true
 }
 
@@ -48,17 +297,388 @@ func (q *JobQueue) Remove(conversionID string) {
 	q.mu.Unlock()
 }
 
+// Depth retorna quantos jobs aguardam nas filas, sem contar o que está em execução.
+func (q *JobQueue) Depth() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.queued
+}
+
+// QueueDepth retorna quantos jobs aguardam na fila nomeada.
+func (q *JobQueue) QueueDepth(name string) int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if nq, ok := q.byName[name]; ok {
+		return len(nq.jobs)
+	}
+	return 0
+}
+
+// QueueNames retorna as filas configuradas em QUEUES, na ordem declarada.
+func (q *JobQueue) QueueNames() []string {
+	names := make([]string, len(q.queues))
+	for i, nq := range q.queues {
+		names[i] = nq.name
+	}
+	return names
+}
+
+// Capacity retorna o número máximo de jobs aguardando, somando todas as filas.
+func (q *JobQueue) Capacity() int {
+	return q.maxSize
+}
+
+// Status retorna o estado do job ativo, incluindo a posição na fila.
+func (q *JobQueue) Status(conversionID string) (JobStatus, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, ok := q.active[conversionID]
+	if !ok {
+		return JobStatus{}, false
+	}
+
+	job.Mu.Lock()
+	status := JobStatus{
+		ConversionID:       job.ID,
+		MediaFileID:        job.Request.MediaFileID,
+		Tenant:             job.Request.Tenant,
+		Queue:              job.Request.Queue,
+		Priority:           job.Request.Priority,
+		State:              JobStateQueued,
+		EnqueuedAt:         job.EnqueuedAt,
+		CompletedQualities: append([]string{}, job.CompletedQualities...),
+		FailedQualities:    append([]string{}, job.FailedQualities...),
+	}
+	job.Mu.Unlock()
+
+	switch {
+	case job.Ctx.Err() != nil && !job.Parked.Load():
+		status.State = JobStateCancelled
+	case q.isPausedLocked(job):
+		status.State = JobStatePaused
+	case job == q.current:
+		status.State = JobStateRunning
+	default:
+		status.QueuePosition = q.byName[job.Request.Queue].indexOf(job) + 1
+		status.Position = q.position(job)
+	}
+	return status, true
+}
+
+// position simula o escalonamento sobre uma cópia das filas e retorna em que
+// ordem o job seria iniciado (1 = próximo), supondo que nada novo chegue.
+// Jobs cancelados são ignorados. Deve ser chamado com q.mu travado.
+func (q *JobQueue) position(job *ConversionJob) int {
+	sim := make([]*namedQueue, len(q.queues))
+	for i, nq := range q.queues {
+		c := *nq
+		sim[i] = &c
+	}
+
+	pos := 0
+	for {
+		nq := nextQueue(sim)
+		if nq == nil {
+			return 0
+		}
+		next := nq.jobs[0]
+		nq.jobs = nq.jobs[1:]
+		if next.Ctx.Err() == nil {
+			pos++
+		}
+		if next == job {
+			return pos
+		}
+	}
+}
+
+// SetPriority altera a prioridade de um job que ainda está na fila,
+// reposicionando-o. Jobs em execução retornam ErrJobNotQueued.
+func (q *JobQueue) SetPriority(conversionID string, priority int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.active[conversionID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	nq := q.byName[job.Request.Queue]
+	if job == q.current || !nq.remove(job) {
+		return ErrJobNotQueued
+	}
+	old := job.Request.Priority
+	job.Request.Priority = priority
+	nq.insert(job)
+
+	jobLogger(job).Info("Prioridade alterada", "component", "queue", "queue", nq.name, "old_priority", old, "priority", priority)
+	return nil
+}
+
+// ActiveCount retorna quantos jobs estão enfileirados ou em processamento.
+func (q *JobQueue) ActiveCount() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.active)
+}
+
 func (q *JobQueue) worker() {
 	defer q.wg.Done()
-	for job := range q.jobs {
-		log.Printf("[QUEUE] Processando job %s", job.ID)
-		processJob(job)
+	for {
+		// Durante o drain nenhum job novo é retirado da fila, mesmo que o
+		// select abaixo tenha os dois casos prontos.
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		if job := q.dequeue(); job != nil {
+			q.run(job)
+			continue
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.ready:
+		}
+	}
+}
+
+func (q *JobQueue) run(job *ConversionJob) {
+	q.mu.Lock()
+	q.current = job
+	q.mu.Unlock()
+
+	logger := jobLogger(job).With("component", "queue")
+	logger.Info("Processando job")
+	start := time.Now()
+	job.Mu.Lock()
+	// Um job retomado de uma pausa mantém o início original.
+	if job.StartedAt.IsZero() {
+		job.StartedAt = time.Now()
+	}
+	job.Mu.Unlock()
+	if job.Ctx.Err() == nil {
+		ev := newEvent(EventJobStarted, job.ID, job.Request, JobQualitiesData{Qualities: job.qualitiesToProcess()})
+		sendEvent(withLogger(job.Ctx, jobLogger(job)), callbackFor(job.Request), ev, nil)
+	}
+	processJob(job)
+
+	q.mu.Lock()
+	q.current = nil
+	q.mu.Unlock()
+
+	switch {
+	case job.Parked.Load() && len(remainingQualities(job)) > 0:
+		q.park(job)
+	case job.Parked.Load():
+		// A pausa chegou depois da última qualidade: não há o que reter.
+		q.finish(job)
+	case job.Interrupted.Load():
+		q.handBack(job)
 		q.Remove(job.ID)
-		log.Printf("[QUEUE] Job %s finalizado", job.ID)
+	default:
+		q.finish(job)
+	}
+	logger.Info("Job finalizado", seconds(time.Since(start)))
+}
+
+// finish tira o job dos ativos e o guarda no histórico.
+func (q *JobQueue) finish(job *ConversionJob) {
+	job.Mu.Lock()
+	job.FinishedAt = time.Now()
+	job.Mu.Unlock()
+	rec := newJobRecord(job, "")
+	q.history.Add(rec)
+	q.Remove(job.ID)
+	tenants.SettleMinutes(job, jobMinutes(job.Request))
+	// O job substituto reporta a mídia.
+	if rec.State != JobStateSuperseded {
+		sendJobCallback(job, rec)
 	}
+	if job.Request.BatchID != "" {
+		q.completeBatch(job.Request.BatchID)
+	}
+}
+
+// Record retorna o job encerrado do histórico.
+func (q *JobQueue) Record(conversionID string) (*JobRecord, bool) {
+	return q.history.Get(conversionID)
 }
 
-func (q *JobQueue) Shutdown() {
-	close(q.jobs)
-	q.wg.Wait()
+// ListJobs retorna os jobs ativos e os do histórico que atendem ao filtro,
+// do mais recente para o mais antigo (por enqueued_at), paginados.
+func (q *JobQueue) ListJobs(f JobFilter) JobList {
+	q.mu.RLock()
+	var records []*JobRecord
+	for _, job := range q.active {
+		state := JobStateQueued
+		switch {
+		case job.Ctx.Err() != nil && !job.Parked.Load():
+			state = JobStateCancelled
+		case q.isPausedLocked(job):
+			state = JobStatePaused
+		case job == q.current:
+			state = JobStateRunning
+		}
+		records = append(records, newJobRecord(job, state))
+	}
+	active := make(map[string]bool, len(q.active))
+	for id := range q.active {
+		active[id] = true
+	}
+	q.mu.RUnlock()
+
+	for _, rec := range q.history.Records() {
+		// Um job repetido via retry aparece só como ativo.
+		if !active[rec.ConversionID] {
+			records = append(records, rec)
+		}
+	}
+
+	list := JobList{Jobs: []*JobRecord{}, Limit: f.Limit, Offset: f.Offset}
+	var matched []*JobRecord
+	for _, rec := range records {
+		if f.match(rec) {
+			matched = append(matched, rec)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].EnqueuedAt.After(matched[j].EnqueuedAt)
+	})
+	list.Total = len(matched)
+	if f.Offset < len(matched) {
+		list.Jobs = matched[f.Offset:min(len(matched), f.Offset+f.Limit)]
+	}
+	return list
+}
+
+// Retry reenfileira, com o mesmo conversion_id, as qualidades de um job
+// encerrado que falharam ou não chegaram a ser processadas (ou o subconjunto
+// informado em qualities). Como na retomada após shutdown, as qualidades já
+// concluídas são mantidas para que a master playlist continue listando-as.
+func (q *JobQueue) Retry(ctx context.Context, conversionID string, qualities []string) (*ConversionJob, error) {
+	q.mu.RLock()
+	_, active := q.active[conversionID]
+	q.mu.RUnlock()
+	if active {
+		return nil, ErrJobActive
+	}
+	prev, ok := q.history.Get(conversionID)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	var pending []string
+	for _, qq := range prev.Request.Qualities {
+		if !containsString(prev.CompletedQualities, qq) {
+			pending = append(pending, qq)
+		}
+	}
+	if len(qualities) == 0 {
+		qualities = pending
+	}
+	for _, qq := range qualities {
+		if !containsString(pending, qq) {
+			return nil, ErrNoRetry
+		}
+	}
+	if len(qualities) == 0 {
+		return nil, ErrNoRetry
+	}
+
+	req := prev.Request
+	req.Qualities = qualities
+	// O histórico guarda o source_url sem a assinatura; o completo só fica em
+	// memória e se perde num restart.
+	if req.SourceURL != "" {
+		if prev.sourceURL == "" {
+			return nil, ErrNoSourceURL
+		}
+		req.SourceURL = prev.sourceURL
+	}
+	if err := tenants.ReserveMinutes(req); err != nil {
+		return nil, err
+	}
+
+	job := newConversionJob(ctx, conversionID, req)
+	job.EnqueuedAt = prev.EnqueuedAt
+	job.CompletedQualities = append([]string{}, prev.CompletedQualities...)
+	if err := q.Enqueue(job); err != nil {
+		job.Cancel()
+		tenants.ReleaseMinutes(req)
+		return nil, err
+	}
+	if req.BatchID != "" {
+		q.batches.Reopen(req.BatchID)
+	}
+	return job, nil
+}
+
+// Shutdown para de aceitar jobs, espera o job em execução terminar até o
+// deadline de ctx e devolve todo o trabalho pendente (ver handBack). Quando o
+// deadline expira, o job em execução é interrompido: as qualidades já
+// concluídas permanecem no storage e apenas as restantes são devolvidas.
+func (q *JobQueue) Shutdown(ctx context.Context) {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	close(q.stop)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	// Um job suspenso não terminaria no drain: é devolvido já.
+	q.mu.RLock()
+	current := q.current
+	q.mu.RUnlock()
+	if current != nil && current.isSuspended() {
+		jobLogger(current).Info("Job pausado interrompido pelo shutdown", "component", "queue")
+		current.Interrupted.Store(true)
+		current.Cancel()
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.mu.RLock()
+		job := q.current
+		q.mu.RUnlock()
+		if job != nil {
+			jobLogger(job).Warn("Prazo de drain esgotado, interrompendo job", "component", "queue")
+			job.Interrupted.Store(true)
+			job.Cancel()
+		}
+		<-done
+	}
+
+	// Jobs que nunca saíram da fila, na ordem em que seriam processados.
+	for job := q.dequeue(); job != nil; job = q.dequeue() {
+		q.drainJob(job)
+	}
+	// Jobs pausados voltam pausados na próxima instância.
+	for _, job := range q.heldJobs() {
+		q.drainJob(job)
+	}
+	q.flushPending()
+	slog.Info("Fila drenada", "component", "queue")
+}
+
+// drainJob devolve um job que não chegou a rodar antes do shutdown. Jobs
+// cancelados ou substituídos enquanto esperavam encerram aqui, como no
+// worker: devolvidos, voltariam a rodar na próxima instância.
+func (q *JobQueue) drainJob(job *ConversionJob) {
+	if job.Ctx.Err() != nil || job.Superseded.Load() {
+		q.finish(job)
+		return
+	}
+	job.Interrupted.Store(true)
+	job.Cancel()
+	q.handBack(job)
+	q.Remove(job.ID)
 }
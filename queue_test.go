@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShutdownFinishesCancelledAndSupersededJobs(t *testing.T) {
+	q := newTestQueue(t)
+	stateFile := filepath.Join(t.TempDir(), "queue.json")
+	t.Setenv("QUEUE_STATE_FILE", stateFile)
+
+	enqueue := func(id string, req ConvertRequest) {
+		t.Helper()
+		req.Qualities = []string{"720p"}
+		if err := q.Enqueue(newConversionJob(context.Background(), id, req)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue("cancelled", ConvertRequest{MediaFileID: 1})
+	enqueue("old", ConvertRequest{MediaFileID: 2})
+	enqueue("new", ConvertRequest{MediaFileID: 2, OnConflict: ConflictSupersede})
+	enqueue("pending", ConvertRequest{MediaFileID: 3})
+	if !q.Cancel("cancelled") {
+		t.Fatal("Cancel não encontrou o job")
+	}
+
+	q.Shutdown(context.Background())
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []pendingJob
+	if err := json.Unmarshal(data, &pending); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range pending {
+		ids = append(ids, p.ConversionID)
+	}
+	// Só o que ainda deveria rodar volta na próxima instância.
+	if got, want := strings.Join(ids, ","), "new,pending"; got != want {
+		t.Errorf("jobs persistidos = %s, want %s", got, want)
+	}
+
+	for id, want := range map[string]string{"cancelled": JobStateCancelled, "old": JobStateSuperseded} {
+		rec, ok := q.Record(id)
+		if !ok {
+			t.Errorf("%s fora do histórico", id)
+			continue
+		}
+		if rec.State != want {
+			t.Errorf("%s: estado = %s, want %s", id, rec.State, want)
+		}
+	}
+	if n := q.ActiveCount(); n != 0 {
+		t.Errorf("jobs ativos após o shutdown = %d", n)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encoders exigidos pelos presets de convertQuality.
+var requiredEncoders = []string{"libx264", "aac"}
+
+// HealthChecker é implementado pelos backends de storage que sabem verificar
+// se estão acessíveis (credenciais, bucket, permissões).
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+func getFFprobePath() string {
+	if p := os.Getenv("FFPROBE_PATH"); p != "" {
+		return p
+	}
+	return "ffprobe"
+}
+
+// binaryCheck guarda o resultado bem-sucedido das verificações do
+// ffmpeg/ffprobe, que são caras (spawn de processo) e mudam raramente.
+type binaryCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]CheckResult
+}
+
+const binaryCheckTTL = 5 * time.Minute
+
+var ffmpegChecks binaryCheck
+
+func (b *binaryCheck) get(ctx context.Context) map[string]CheckResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.results != nil && time.Since(b.checked) < binaryCheckTTL {
+		return b.results
+	}
+
+	results := map[string]CheckResult{
+		"ffmpeg":  checkBinaryVersion(ctx, getFFmpegPath()),
+		"ffprobe": checkBinaryVersion(ctx, getFFprobePath()),
+	}
+	if results["ffmpeg"].Status == CheckOK {
+		results["codecs"] = checkEncoders(ctx)
+	} else {
+		results["codecs"] = CheckResult{Status: CheckFailed, Error: "ffmpeg indisponível"}
+	}
+
+	// Só guarda em cache quando tudo passou, para que uma falha se recupere
+	// na próxima verificação.
+	if results["ffmpeg"].Status == CheckOK && results["ffprobe"].Status == CheckOK && results["codecs"].Status == CheckOK {
+		b.results = results
+		b.checked = time.Now()
+	}
+	return results
+}
+
+func checkBinaryVersion(ctx context.Context, bin string) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, bin, "-version").Output()
+	if err != nil {
+		return CheckResult{Status: CheckFailed, Error: fmt.Sprintf("erro ao executar %s: %v", bin, err)}
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return CheckResult{Status: CheckOK, Detail: map[string]any{"path": bin, "version": strings.TrimSpace(line)}}
+}
+
+func checkEncoders(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, getFFmpegPath(), "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return CheckResult{Status: CheckFailed, Error: fmt.Sprintf("erro ao listar encoders: %v", err)}
+	}
+
+	// Linhas no formato " V....D libx264              libx264 H.264 ..."
+	available := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 {
+			available[fields[1]] = true
+		}
+	}
+
+	var missing []string
+	for _, enc := range requiredEncoders {
+		if !available[enc] {
+			missing = append(missing, enc)
+		}
+	}
+	detail := map[string]any{"required": requiredEncoders}
+	if len(missing) > 0 {
+		detail["missing"] = missing
+		return CheckResult{Status: CheckFailed, Error: "encoders ausentes: " + strings.Join(missing, ", "), Detail: detail}
+	}
+	return CheckResult{Status: CheckOK, Detail: detail}
+}
+
+func checkStorage(ctx context.Context, newStorage func() (Storage, error)) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	st, err := newStorage()
+	if err != nil {
+		return CheckResult{Status: CheckFailed, Error: err.Error()}
+	}
+	hc, ok := st.(HealthChecker)
+	if !ok {
+		return CheckResult{Status: CheckOK}
+	}
+	if err := hc.CheckHealth(ctx); err != nil {
+		return CheckResult{Status: CheckFailed, Error: err.Error()}
+	}
+	return CheckResult{Status: CheckOK}
+}
+
+func checkTempDisk() CheckResult {
+	dir := getTempDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return CheckResult{Status: CheckFailed, Error: fmt.Sprintf("erro ao criar %s: %v", dir, err)}
+	}
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return CheckResult{Status: CheckFailed, Error: err.Error()}
+	}
+
+	minFree := uint64(getEnvInt("READY_MIN_FREE_DISK_MB", 10240)) << 20
+	detail := map[string]any{"path": dir, "free_bytes": free, "total_bytes": total, "min_free_bytes": minFree}
+	if free < minFree {
+		return CheckResult{Status: CheckFailed, Error: "espaço livre insuficiente em TEMP_DIR", Detail: detail}
+	}
+	return CheckResult{Status: CheckOK, Detail: detail}
+}
+
+func checkQueue(q *JobQueue) CheckResult {
+	depth, capacity := q.Depth(), q.Capacity()
+	// Com fila pequena, 90% arredonda para 0 e a verificação falharia sempre.
+	maxDepth := max(1, getEnvInt("READY_MAX_QUEUE_DEPTH", capacity*9/10))
+	detail := map[string]any{"depth": depth, "capacity": capacity, "max_depth": maxDepth, "active": q.ActiveCount()}
+	if depth >= maxDepth {
+		return CheckResult{Status: CheckFailed, Error: "fila saturada", Detail: detail}
+	}
+	return CheckResult{Status: CheckOK, Detail: detail}
+}
+
+// runReadinessChecks executa todas as verificações; o serviço só está pronto
+// se todas passarem.
+func runReadinessChecks(ctx context.Context, q *JobQueue) ReadyResponse {
+	checks := make(map[string]CheckResult)
+	for name, result := range ffmpegChecks.get(ctx) {
+		checks[name] = result
+	}
+	checks["temp_disk"] = checkTempDisk()
+	checks["queue"] = checkQueue(q)
+
+	// As verificações de storage fazem chamadas de rede: rodam em paralelo.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, fn := range map[string]func() CheckResult{
+		"input_storage":  func() CheckResult { return checkStorage(ctx, NewInputStorage) },
+		"output_storage": func() CheckResult { return checkStorage(ctx, NewOutputStorage) },
+	} {
+		wg.Add(1)
+		go func(name string, fn func() CheckResult) {
+			defer wg.Done()
+			result := fn()
+			mu.Lock()
+			checks[name] = result
+			mu.Unlock()
+		}(name, fn)
+	}
+	wg.Wait()
+
+	status := "ready"
+	for _, c := range checks {
+		if c.Status != CheckOK {
+			status = "not_ready"
+			break
+		}
+	}
+	return ReadyResponse{Status: status, Checks: checks}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCheckQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		depth    int
+		maxDepth string
+		want     string
+	}{
+		{"vazia", 100, 0, "", CheckOK},
+		{"abaixo de 90%", 100, 89, "", CheckOK},
+		{"90%", 100, 90, "", CheckFailed},
+		{"capacidade 1 vazia", 1, 0, "", CheckOK},
+		{"capacidade 1 cheia", 1, 1, "", CheckFailed},
+		{"limite configurado", 100, 10, "10", CheckFailed},
+		{"limite zero", 100, 0, "0", CheckOK},
+	}
+	for _, tt := range tests {
+		t.Setenv("READY_MAX_QUEUE_DEPTH", tt.maxDepth)
+		q := &JobQueue{maxSize: tt.capacity, queued: tt.depth}
+		if got := checkQueue(q); got.Status != tt.want {
+			t.Errorf("%s: status = %s, want %s (%v)", tt.name, got.Status, tt.want, got.Detail)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Modos de conversão. repair recodifica só as qualidades pedidas que ainda
+// não existem no storage (ausentes ou que falharam) e regrava a master
+// playlist com a união das renditions existentes e das novas.
+const (
+	ConvertModeFull   = "full"
+	ConvertModeRepair = "repair"
+)
+
+// ErrLayoutNotRepairable recusa repair em layouts que mudam a cada
+// submissão: as renditions anteriores nunca seriam encontradas e tudo seria
+// recodificado num prefixo novo.
+var ErrLayoutNotRepairable = errors.New("o modo repair exige que o layout de saída use apenas {media_id}, {tenant} e {quality} (no modo versioned, só a master playlist), com {media_id}")
+
+func validConvertMode(mode string) bool {
+	switch mode {
+	case "", ConvertModeFull, ConvertModeRepair:
+		return true
+	}
+	return false
+}
+
+// validateRepairLayout confere se as renditions de uma submissão anterior
+// podem ser localizadas: a master (que também localiza o manifest) e, no modo
+// in_place, as renditions precisam ser renderizáveis sem o job que as gerou.
+func validateRepairLayout(req ConvertRequest) error {
+	rendition, master := outputTemplates(req)
+	if !isMediaTemplate(master) {
+		return ErrLayoutNotRepairable
+	}
+	if publishMode(req) == PublishInPlace && !isMediaTemplate(rendition) {
+		return ErrLayoutNotRepairable
+	}
+	return nil
+}
+
+// existingRenditions retorna as renditions já publicadas da mídia
+// (qualidade -> chave da playlist). No modo versioned vêm do manifest; no
+// in_place, das playlists completas encontradas no layout do job.
+func existingRenditions(ctx context.Context, output Storage, tempDir string, layout OutputLayout, versioned bool) (map[string]string, error) {
+	existing := make(map[string]string)
+
+	if versioned {
+		publishMu.Lock()
+		manifest, err := readManifest(ctx, output, tempDir, manifestKey(layout))
+		publishMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			for q, key := range manifest.Renditions {
+				existing[q] = key
+			}
+		}
+		return existing, nil
+	}
+
+	for q := range QualityMap {
+		key := layout.RenditionPlaylist(q)
+		ok, err := renditionComplete(ctx, output, tempDir, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			existing[q] = key
+		}
+	}
+	return existing, nil
+}
+
+// renditionComplete indica se a playlist da qualidade existe, foi finalizada
+// (#EXT-X-ENDLIST) e se o último segmento foi enviado. Um upload interrompido
+// pode deixar a playlist sem parte dos segmentos; conferir o último evita um
+// HEAD por segmento.
+func renditionComplete(ctx context.Context, output Storage, tempDir, playlistKey string) (bool, error) {
+	exists, err := output.Exists(ctx, playlistKey)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	localPath := filepath.Join(tempDir, "repair-"+strings.ReplaceAll(playlistKey, "/", "_"))
+	if err := output.Download(ctx, playlistKey, localPath); err != nil {
+		return false, err
+	}
+	defer os.Remove(localPath)
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var lastSegment string
+	var ended bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case line != "" && !strings.HasPrefix(line, "#"):
+			lastSegment = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if !ended || lastSegment == "" {
+		return false, nil
+	}
+	return output.Exists(ctx, path.Join(path.Dir(playlistKey), lastSegment))
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRepairLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		req     ConvertRequest
+		wantErr bool
+	}{
+		{"in_place padrão", PublishInPlace, ConvertRequest{}, false},
+		{"versioned padrão", PublishVersioned, ConvertRequest{}, false},
+		{"in_place com conversion_id", PublishInPlace, ConvertRequest{RenditionTemplate: "hls/{media_id}/{conversion_id}/{quality}"}, true},
+		{"in_place com date", PublishInPlace, ConvertRequest{RenditionTemplate: "hls/{date}/{media_id}/{quality}"}, true},
+		{"master com date", PublishVersioned, ConvertRequest{MasterTemplate: "hls/{date}/{media_id}/master.m3u8"}, true},
+		{"master sem media_id", PublishInPlace, ConvertRequest{MasterTemplate: "hls/master.m3u8"}, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("PUBLISH_MODE", tt.mode)
+		err := validateRepairLayout(tt.req)
+		if tt.wantErr != errors.Is(err, ErrLayoutNotRepairable) || (!tt.wantErr && err != nil) {
+			t.Errorf("%s: validateRepairLayout = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pendingJob é um job devolvido no shutdown, persistido em QUEUE_STATE_FILE
+// para ser retomado pela próxima instância.
+type pendingJob struct {
+	ConversionID string         `json:"conversion_id"`
+	Request      ConvertRequest `json:"request"`
+	EnqueuedAt   time.Time      `json:"enqueued_at"`
+
+	// CompletedQualities são as qualidades já entregues antes do shutdown,
+	// para que a master playlist continue listando-as na retomada.
+	CompletedQualities []string `json:"completed_qualities,omitempty"`
+
+	// Paused indica que o job estava pausado e volta retido até o resume.
+	Paused bool `json:"paused,omitempty"`
+}
+
+func getQueueStateFile() string {
+	return os.Getenv("QUEUE_STATE_FILE")
+}
+
+// qualitiesToProcess retorna as qualidades que o job deve processar.
+func (j *ConversionJob) qualitiesToProcess() []string {
+	if len(j.remaining) > 0 {
+		return j.remaining
+	}
+	return j.Request.Qualities
+}
+
+// remainingQualities retorna as qualidades do job que ainda não foram
+// concluídas nem falharam.
+func remainingQualities(job *ConversionJob) []string {
+	job.Mu.Lock()
+	defer job.Mu.Unlock()
+
+	done := make(map[string]bool)
+	for _, q := range job.CompletedQualities {
+		done[q] = true
+	}
+	for _, q := range job.FailedQualities {
+		done[q] = true
+	}
+
+	var remaining []string
+	for _, q := range job.qualitiesToProcess() {
+		if !done[q] {
+			remaining = append(remaining, q)
+		}
+	}
+	return remaining
+}
+
+// handBack devolve as qualidades pendentes de um job interrompido. Com
+// QUEUE_STATE_FILE configurado o job é persistido e retomado no próximo
+// start; sem ele, o Laravel recebe um callback "requeued" por qualidade para
+// reenviar a conversão.
+func (q *JobQueue) handBack(job *ConversionJob) {
+	remaining := remainingQualities(job)
+
+	// Persistido, o job segue com a reserva das qualidades restantes; via
+	// callback, o Laravel reenvia e reserva de novo.
+	reserved := jobMinutes(job.Request)
+	if len(remaining) > 0 && getQueueStateFile() != "" {
+		req := job.Request
+		req.Qualities = remaining
+		reserved -= jobMinutes(req)
+	}
+	tenants.SettleMinutes(job, reserved)
+
+	if len(remaining) == 0 {
+		return
+	}
+
+	logger := jobLogger(job).With("component", "queue", "qualities", remaining)
+
+	if getQueueStateFile() != "" {
+		req := job.Request
+		req.Qualities = remaining
+		job.Mu.Lock()
+		completed := append([]string{}, job.CompletedQualities...)
+		job.Mu.Unlock()
+		q.mu.Lock()
+		paused := q.isPausedLocked(job)
+		q.pending = append(q.pending, pendingJob{ConversionID: job.ID, Request: req, EnqueuedAt: job.EnqueuedAt, CompletedQualities: completed, Paused: paused})
+		q.mu.Unlock()
+		logger.Info("Job devolvido para persistência")
+		return
+	}
+
+	q.handBackViaCallback(job, remaining)
+	logger.Info("Job devolvido via callback requeued")
+}
+
+func (q *JobQueue) handBackViaCallback(job *ConversionJob, qualities []string) {
+	ctx := withLogger(context.Background(), jobLogger(job))
+	sendRequeuedCallback(ctx, job.ID, job.Request, qualities)
+}
+
+// flushPending grava em QUEUE_STATE_FILE os jobs devolvidos.
+func (q *JobQueue) flushPending() {
+	path := getQueueStateFile()
+	q.mu.RLock()
+	pending := q.pending
+	q.mu.RUnlock()
+	if path == "" || len(pending) == 0 {
+		return
+	}
+
+	if err := writeQueueState(path, pending); err != nil {
+		// Sem conseguir persistir, avisa o Laravel para não perder o trabalho.
+		slog.Error("Erro ao persistir fila, enviando callbacks requeued", "component", "queue", "path", path, "error", err.Error())
+		for _, p := range pending {
+			sendRequeuedCallback(context.Background(), p.ConversionID, p.Request, p.Request.Qualities)
+		}
+		return
+	}
+	slog.Info("Fila persistida", "component", "queue", "path", path, "jobs", len(pending))
+}
+
+func writeQueueState(path string, pending []pendingJob) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestorePending reenfileira os jobs persistidos no último shutdown,
+// mantendo os conversion_id originais, e apaga o arquivo de estado.
+func (q *JobQueue) RestorePending() error {
+	path := getQueueStateFile()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+
+	var pending []pendingJob
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return fmt.Errorf("erro ao interpretar %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("erro ao remover %s: %w", path, err)
+	}
+
+	for _, p := range pending {
+		job := newConversionJob(context.Background(), p.ConversionID, p.Request)
+		// Mantém a data original para que {date} no layout de saída não mude.
+		job.EnqueuedAt = p.EnqueuedAt
+		job.CompletedQualities = p.CompletedQualities
+		enqueue := q.Enqueue
+		if p.Paused {
+			enqueue = q.enqueuePaused
+		}
+		if err := enqueue(job); err != nil {
+			jobLogger(job).Error("Erro ao restaurar job, devolvendo via callback", "component", "queue", "error", err.Error())
+			job.Cancel()
+			q.handBackViaCallback(job, p.Request.Qualities)
+		}
+	}
+	slog.Info("Jobs restaurados do último shutdown", "component", "queue", "path", path, "jobs", len(pending))
+	return nil
+}
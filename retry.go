@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// RetryPolicy define quantas vezes uma fase do job é tentada e o intervalo
+// entre as tentativas, que dobra a cada falha até MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+var defaultRetryPolicies = map[string]RetryPolicy{
+	PhaseDownload: {MaxAttempts: 3, Backoff: 10 * time.Second},
+	PhaseEncode:   {MaxAttempts: 2, Backoff: 30 * time.Second},
+}
+
+// getRetryPolicy lê RETRY_<FASE>_MAX_ATTEMPTS e RETRY_<FASE>_BACKOFF_SECONDS
+// (por exemplo RETRY_ENCODE_MAX_ATTEMPTS). MaxAttempts 1 desliga os retries.
+// O upload não tem política de fase: cada objeto já é repetido por withRetry.
+func getRetryPolicy(phase string) RetryPolicy {
+	p := defaultRetryPolicies[phase]
+	prefix := "RETRY_" + strings.ToUpper(phase) + "_"
+	p.MaxAttempts = max(1, getEnvInt(prefix+"MAX_ATTEMPTS", max(1, p.MaxAttempts)))
+	p.Backoff = time.Duration(getEnvInt(prefix+"BACKOFF_SECONDS", int(p.Backoff/time.Second))) * time.Second
+	p.MaxBackoff = time.Duration(getEnvInt("RETRY_MAX_BACKOFF_SECONDS", 300)) * time.Second
+	return p
+}
+
+// permanentError marca erros em que tentar novamente não adianta: entrada
+// inválida, configuração incorreta.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Trechos do stderr do ffmpeg que indicam falha de rede ou de I/O ao ler o
+// original (streaming), e não um arquivo inválido.
+var transientFFmpegErrors = []string{
+	"Connection reset",
+	"Connection refused",
+	"Connection timed out",
+	"timed out",
+	"Server returned 5",
+	"Input/output error",
+	"Cannot allocate memory",
+}
+
+// isTransientError indica se vale a pena repetir a operação. Só erros
+// reconhecidamente transitórios são repetidos; qualquer outro (disco cheio,
+// permissão negada, entrada inválida) é tratado como permanente. Erros do
+// ffmpeg só são transitórios se o processo foi morto por sinal (OOM kill) ou
+// se o stderr aponta falha de rede/I/O.
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pe *permanentError
+	var pse *permanentSourceError
+	if errors.As(err, &pe) || errors.As(err, &pse) {
+		return false
+	}
+
+	var fe *ffmpegError
+	if errors.As(err, &fe) {
+		if fe.signaled {
+			return true
+		}
+		for _, s := range transientFFmpegErrors {
+			if strings.Contains(fe.stderr, s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		return isTransientStatus(respErr.HTTPStatusCode())
+	}
+	var statusErr *sourceStatusError
+	if errors.As(err, &statusErr) {
+		return isTransientStatus(statusErr.code)
+	}
+	return isTransientNetworkError(err)
+}
+
+// isTransientStatus indica se a resposta HTTP vale nova tentativa: 5xx,
+// throttling (429) e timeout da requisição (408).
+func isTransientStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// isTransientNetworkError reconhece falhas de rede sem resposta HTTP: timeout,
+// conexão recusada ou resetada, corpo interrompido antes do fim.
+func isTransientNetworkError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func errorKind(err error) string {
+	if isTransientError(err) {
+		return "transient"
+	}
+	return "permanent"
+}
+
+// retryPhase executa fn segundo a política da fase, repetindo apenas erros
+// transitórios. Cancelamentos interrompem a espera imediatamente.
+func retryPhase(ctx context.Context, phase string, fn func() error) error {
+	policy := getRetryPolicy(phase)
+	backoff := policy.Backoff
+
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if err = fn(); err == nil || ctx.Err() != nil {
+			return err
+		}
+		if attempt == policy.MaxAttempts || !isTransientError(err) {
+			break
+		}
+
+		ctxLogger(ctx, "retry").Warn("Falha transitória, tentando novamente",
+			append([]any{"phase", phase, "attempt", attempt, "max_attempts", policy.MaxAttempts, "retry_in", backoff.String()}, errAttrs(err)...)...)
+		observeRetry(phase)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+	return err
+}
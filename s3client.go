@@ -2,76 +2,137 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type S3Client struct {
-	client *s3.Client
-	bucket string
+	client     *s3.Client
+	bucket     string
+	uploadOpts UploadOptions
 }
 
-func NewS3Client() (*S3Client, error) {
-	bucket := os.Getenv("AWS_BUCKET")
-	if bucket == "" {
-		return nil, fmt.Errorf("AWS_BUCKET não configurado")
-	}
+// S3Config descreve como conectar ao bucket. Endpoint e UsePathStyle permitem
+// usar stores compatíveis com S3 (MinIO, Ceph, R2).
+type S3Config struct {
+	Bucket             string
+	Region             string
+	AccessKey          string
+	SecretKey          string
+	Endpoint           string
+	UsePathStyle       bool
+	InsecureSkipVerify bool
+}
 
-	region := os.Getenv("AWS_DEFAULT_REGION")
+func loadS3ConfigFromEnv() S3Config {
+	// AWS_S3_REGION sobrescreve a região padrão apenas para o S3, útil quando o
+	// store compatível exige uma região própria (ex.: "auto" no R2).
+	region := os.Getenv("AWS_S3_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
 	if region == "" {
 		region = "us-east-1"
 	}
 
-	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	return S3Config{
+		Bucket:             os.Getenv("AWS_BUCKET"),
+		Region:             region,
+		AccessKey:          os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:          os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Endpoint:           os.Getenv("AWS_ENDPOINT"),
+		UsePathStyle:       getEnvBool("AWS_USE_PATH_STYLE_ENDPOINT", false),
+		InsecureSkipVerify: !getEnvBool("AWS_S3_TLS_VERIFY", true),
+	}
+}
 
-	var cfg aws.Config
-	var err error
+func NewS3ClientFromConfig(c S3Config) (*S3Client, error) {
+	if c.Bucket == "" {
+		return nil, fmt.Errorf("AWS_BUCKET não configurado")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
 
-	if accessKey != "" && secretKey != "" {
-		cfg, err = config.LoadDefaultConfig(context.Background(),
-			config.WithRegion(region),
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
-		)
-	} else {
-		cfg, err = config.LoadDefaultConfig(context.Background(),
-			config.WithRegion(region),
-		)
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(c.Region),
 	}
+	if c.AccessKey != "" && c.SecretKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(c.AccessKey, c.SecretKey, "")))
+	}
+	if c.InsecureSkipVerify {
+		slog.Warn("Verificação TLS desabilitada para o endpoint S3", "component", "s3", "endpoint", c.Endpoint)
+		httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao carregar configuração AWS: %w", err)
 	}
 
-	client := s3.NewFromConfig(cfg)
-	return &S3Client{client: client, bucket: bucket}, nil
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		o.UsePathStyle = c.UsePathStyle
+	})
+	return &S3Client{client: client, bucket: c.Bucket, uploadOpts: getUploadOptions()}, nil
+}
+
+// decodeS3Key decodifica o s3Path, caso ele já venha com URL encoding.
+// O SDK do S3 espera a chave "crua" (não codificada), pois ele mesmo faz a codificação.
+func decodeS3Key(s3Path string) string {
+	decoded, err := url.PathUnescape(s3Path)
+	if err != nil {
+		// Se a decodificação falhar, usar o caminho original e logar um aviso.
+		slog.Warn("Falha ao decodificar s3Path, usando o caminho original", "component", "s3", "key", s3Path, "error", err.Error())
+		return s3Path
+	}
+	return decoded
 }
 
-func (s *S3Client) Download(ctx context.Context, s3Path string, localPath string) error {
+func (s *S3Client) Download(ctx context.Context, s3Path string, localPath string) (err error) {
+	ctx, span := tracer.Start(ctx, "S3Client.Download", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", s3Path),
+	))
+	defer func() { endSpan(span, err) }()
+
 	dir := filepath.Dir(localPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("erro ao criar diretório %s: %w", dir, err)
 	}
 
-	// Tentativa de decodificar o s3Path, caso ele já venha com URL encoding.
-	// O SDK do S3 espera a chave "crua" (não codificada), pois ele mesmo faz a codificação.
-	decodedS3Path, err := url.PathUnescape(s3Path)
-	if err != nil {
-		// Se a decodificação falhar, usar o caminho original e logar um aviso.
-		log.Printf("[S3] Aviso: falha ao decodificar s3Path '%s': %v. Usando o caminho original.", s3Path, err)
-		decodedS3Path = s3Path
-	}
+	decodedS3Path := decodeS3Key(s3Path)
 
-	log.Printf("[S3] Baixando s3://%s/%s -> %s", s.bucket, decodedS3Path, localPath)
+	logger := ctxLogger(ctx, "s3").With("phase", PhaseDownload, "bucket", s.bucket, "key", decodedS3Path)
+	logger.Info("Baixando do S3", "local_path", localPath)
+	start := time.Now()
 
 	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -93,36 +154,52 @@ func (s *S3Client) Download(ctx context.Context, s3Path string, localPath string
 		return fmt.Errorf("erro ao escrever arquivo: %w", err)
 	}
 
-	log.Printf("[S3] Download concluído: %d bytes", written)
+	metricBytesDownloaded.WithLabelValues(StorageBackendS3).Add(float64(written))
+	span.SetAttributes(attribute.Int64("hls.bytes", written))
+	logger.Info("Download concluído", "bytes", written, seconds(time.Since(start)))
 	return nil
 }
 
-func (s *S3Client) Upload(ctx context.Context, localPath string, s3Path string) error {
-	file, err := os.Open(localPath)
+func (s *S3Client) Upload(ctx context.Context, localPath string, s3Path string) (err error) {
+	ctx, span := tracer.Start(ctx, "S3Client.Upload", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", s3Path),
+	))
+	defer func() { endSpan(span, err) }()
+
+	info, err := os.Stat(localPath)
 	if err != nil {
 		return fmt.Errorf("erro ao abrir arquivo %s: %w", localPath, err)
 	}
-	defer file.Close()
 
 	contentType := getContentType(localPath)
+	span.SetAttributes(attribute.Int64("hls.bytes", info.Size()))
 
-	log.Printf("[S3] Enviando %s -> s3://%s/%s (Content-Type: %s)", localPath, s.bucket, s3Path, contentType)
+	if info.Size() >= s.uploadOpts.MultipartThreshold {
+		ctxLogger(ctx, "s3").Info("Enviando via multipart", "phase", PhaseUpload, "bucket", s.bucket, "key", s3Path, "bytes", info.Size(), "content_type", contentType)
+		if err := s.uploadMultipart(ctx, localPath, s3Path, contentType, info.Size()); err != nil {
+			return fmt.Errorf("erro ao enviar para S3: %w", err)
+		}
+		metricBytesUploaded.WithLabelValues(StorageBackendS3).Add(float64(info.Size()))
+		return nil
+	}
 
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(s3Path),
-		Body:        file,
-		ContentType: aws.String(contentType),
+	ctxLogger(ctx, "s3").Debug("Enviando para o S3", "phase", PhaseUpload, "bucket", s.bucket, "key", s3Path, "content_type", contentType)
+
+	err = withRetry(ctx, s.uploadOpts.MaxAttempts, s3Path, func() error {
+		return s.putObject(ctx, localPath, s3Path, contentType)
 	})
 	if err != nil {
 		return fmt.Errorf("erro ao enviar para S3: %w", err)
 	}
 
+	metricBytesUploaded.WithLabelValues(StorageBackendS3).Add(float64(info.Size()))
 	return nil
 }
 
 func (s *S3Client) UploadDirectory(ctx context.Context, localDir string, s3Prefix string) error {
-	return filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+	var items []uploadItem
+	err := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -130,20 +207,124 @@ func (s *S3Client) UploadDirectory(ctx context.Context, localDir string, s3Prefi
 			return nil
 		}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
 		relPath, err := filepath.Rel(localDir, path)
 		if err != nil {
 			return err
 		}
 
 		s3Key := s3Prefix + "/" + strings.ReplaceAll(relPath, string(os.PathSeparator), "/")
-		return s.Upload(ctx, path, s3Key)
+		items = append(items, uploadItem{localPath: path, s3Key: s3Key})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.uploadFiles(ctx, items)
+}
+
+func (s *S3Client) Delete(ctx context.Context, s3Path string) error {
+	ctxLogger(ctx, "s3").Info("Removendo do S3", "bucket", s.bucket, "key", s3Path)
+
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3Path),
 	})
+	if err != nil {
+		return fmt.Errorf("erro ao remover de S3: %w", err)
+	}
+	return nil
+}
+
+// s3DeleteBatchSize é o máximo de chaves aceito por DeleteObjects.
+const s3DeleteBatchSize = 1000
+
+func (s *S3Client) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	prefix, err := dirPrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	logger := ctxLogger(ctx, "s3").With("bucket", s.bucket, "prefix", prefix)
+
+	deleted := 0
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(s3DeleteBatchSize),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, fmt.Errorf("erro ao listar %s no S3: %w", prefix, err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, len(page.Contents))
+		for i, obj := range page.Contents {
+			objects[i] = types.ObjectIdentifier{Key: obj.Key}
+		}
+
+		var out *s3.DeleteObjectsOutput
+		err = withRetry(ctx, s.uploadOpts.MaxAttempts, prefix, func() error {
+			var err error
+			out, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(s.bucket),
+				Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("erro ao remover objetos de %s no S3: %w", prefix, err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return deleted + len(objects) - len(out.Errors), fmt.Errorf("erro ao remover %s do S3: %s (%d falhas)",
+				aws.ToString(e.Key), aws.ToString(e.Message), len(out.Errors))
+		}
+		deleted += len(objects)
+	}
+
+	logger.Info("Prefixo removido do S3", "objects", deleted)
+	return deleted, nil
+}
+
+func (s *S3Client) Exists(ctx context.Context, s3Path string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3Path),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return false, fmt.Errorf("erro ao consultar objeto no S3: %w", err)
+}
+
+// CheckHealth confirma que as credenciais têm acesso ao bucket.
+func (s *S3Client) CheckHealth(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	if err != nil {
+		return fmt.Errorf("sem acesso ao bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// InputURL gera uma URL pré-assinada de leitura para o ffmpeg consumir o
+// original direto do bucket.
+func (s *S3Client) InputURL(ctx context.Context, s3Path string, ttl time.Duration) (string, error) {
+	presigned, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(decodeS3Key(s3Path)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("erro ao pré-assinar URL: %w", err)
+	}
+	return presigned.URL, nil
 }
 
 func getContentType(path string) string {
@@ -155,6 +336,8 @@ func getContentType(path string) string {
 		return "video/MP2T"
 	case ".mp4":
 		return "video/mp4"
+	case ".json":
+		return "application/json"
 	default:
 		return "application/octet-stream"
 	}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultQueueName = "default"
+
+// namedQueue é uma fila nomeada (por exemplo "interactive" ou "backfill").
+// Os jobs ficam ordenados por prioridade (maior primeiro) e, dentro da mesma
+// prioridade, por ordem de chegada.
+type namedQueue struct {
+	name    string
+	weight  int
+	current int
+	jobs    []*ConversionJob
+}
+
+// getQueueConfigs lê QUEUES no formato "nome:peso,nome:peso". A primeira
+// fila é a padrão para requests sem "queue". Pesos inválidos valem 1.
+func getQueueConfigs() []*namedQueue {
+	raw := os.Getenv("QUEUES")
+	if raw == "" {
+		raw = defaultQueueName + ":1"
+	}
+
+	var queues []*namedQueue
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(raw, ",") {
+		name, weightStr, _ := strings.Cut(strings.TrimSpace(item), ":")
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		weight := 1
+		if weightStr != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || n <= 0 {
+				slog.Warn("Peso de fila inválido, usando 1", "component", "config", "queue", name, "value", weightStr)
+			} else {
+				weight = n
+			}
+		}
+		seen[name] = true
+		queues = append(queues, &namedQueue{name: name, weight: weight})
+	}
+	if len(queues) == 0 {
+		queues = append(queues, &namedQueue{name: defaultQueueName, weight: 1})
+	}
+	return queues
+}
+
+// insert posiciona o job pela prioridade e, entre jobs de mesma prioridade,
+// pela ordem de chegada.
+func (nq *namedQueue) insert(job *ConversionJob) {
+	i := sort.Search(len(nq.jobs), func(i int) bool {
+		other := nq.jobs[i]
+		if other.Request.Priority != job.Request.Priority {
+			return other.Request.Priority < job.Request.Priority
+		}
+		return other.seq > job.seq
+	})
+	nq.jobs = append(nq.jobs, nil)
+	copy(nq.jobs[i+1:], nq.jobs[i:])
+	nq.jobs[i] = job
+}
+
+func (nq *namedQueue) remove(job *ConversionJob) bool {
+	for i, j := range nq.jobs {
+		if j == job {
+			nq.jobs = append(nq.jobs[:i], nq.jobs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (nq *namedQueue) indexOf(job *ConversionJob) int {
+	for i, j := range nq.jobs {
+		if j == job {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextQueue escolhe a próxima fila por weighted round-robin suave (o mesmo
+// algoritmo do nginx): entre as filas não vazias, uma fila de peso 3 é
+// atendida três vezes para cada vez de uma fila de peso 1, intercaladas, e
+// nenhuma fila com jobs fica sem ser atendida.
+func nextQueue(queues []*namedQueue) *namedQueue {
+	var best *namedQueue
+	total := 0
+	for _, nq := range queues {
+		if len(nq.jobs) == 0 {
+			continue
+		}
+		nq.current += nq.weight
+		total += nq.weight
+		if best == nil || nq.current > best.current {
+			best = nq
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
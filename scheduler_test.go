@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// queueWithJobs cria uma fila com n jobs de mesma prioridade.
+func queueWithJobs(name string, weight, n int) *namedQueue {
+	nq := &namedQueue{name: name, weight: weight}
+	for i := 0; i < n; i++ {
+		nq.insert(&ConversionJob{seq: uint64(i)})
+	}
+	return nq
+}
+
+func TestNextQueueWeighted(t *testing.T) {
+	interactive := queueWithJobs("interactive", 3, 100)
+	backfill := queueWithJobs("backfill", 1, 100)
+	queues := []*namedQueue{interactive, backfill}
+
+	var order []string
+	for i := 0; i < 8; i++ {
+		order = append(order, nextQueue(queues).name[:1])
+	}
+	// Peso 3:1, intercalado: a fila de peso 1 não espera as três seguidas.
+	if got, want := strings.Join(order, ""), "iibiiibi"; got != want {
+		t.Errorf("ordem = %s, want %s", got, want)
+	}
+}
+
+func TestNextQueueSkipsEmpty(t *testing.T) {
+	empty := &namedQueue{name: "interactive", weight: 5}
+	backfill := queueWithJobs("backfill", 1, 1)
+	queues := []*namedQueue{empty, backfill}
+
+	for i := 0; i < 3; i++ {
+		if got := nextQueue(queues); got != backfill {
+			t.Fatalf("nextQueue = %v, want backfill", got)
+		}
+	}
+	// Uma fila vazia não acumula crédito enquanto espera.
+	if empty.current != 0 {
+		t.Errorf("current da fila vazia = %d, want 0", empty.current)
+	}
+
+	backfill.jobs = nil
+	if got := nextQueue(queues); got != nil {
+		t.Errorf("nextQueue sem jobs = %v, want nil", got.name)
+	}
+}
+
+func TestNextQueueNoStarvation(t *testing.T) {
+	queues := []*namedQueue{queueWithJobs("a", 10, 100), queueWithJobs("b", 1, 100)}
+	counts := map[string]int{}
+	for i := 0; i < 22; i++ {
+		counts[nextQueue(queues).name]++
+	}
+	if counts["a"] != 20 || counts["b"] != 2 {
+		t.Errorf("atendimentos = %v, want a=20 b=2", counts)
+	}
+}
+
+func TestNamedQueueInsertOrder(t *testing.T) {
+	nq := &namedQueue{name: "default", weight: 1}
+	low := &ConversionJob{ID: "low", seq: 1, Request: ConvertRequest{Priority: 0}}
+	high := &ConversionJob{ID: "high", seq: 2, Request: ConvertRequest{Priority: 10}}
+	lowLater := &ConversionJob{ID: "low-later", seq: 3, Request: ConvertRequest{Priority: 0}}
+	for _, job := range []*ConversionJob{low, high, lowLater} {
+		nq.insert(job)
+	}
+
+	var ids []string
+	for _, job := range nq.jobs {
+		ids = append(ids, job.ID)
+	}
+	if got, want := strings.Join(ids, ","), "high,low,low-later"; got != want {
+		t.Errorf("ordem = %s, want %s", got, want)
+	}
+}
+
+func TestGetQueueConfigs(t *testing.T) {
+	t.Setenv("QUEUES", "interactive:3, backfill:x ,interactive:9,,bulk")
+	queues := getQueueConfigs()
+
+	var got []string
+	for _, nq := range queues {
+		got = append(got, fmt.Sprintf("%s:%d", nq.name, nq.weight))
+	}
+	if want := "interactive:3,backfill:1,bulk:1"; strings.Join(got, ",") != want {
+		t.Errorf("filas = %s, want %s", strings.Join(got, ","), want)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Storage abstrai onde os originais são lidos e onde as saídas HLS são gravadas.
+// As chaves usam sempre "/" como separador, independente do backend.
+type Storage interface {
+	Download(ctx context.Context, key string, localPath string) error
+	Upload(ctx context.Context, localPath string, key string) error
+	UploadDirectory(ctx context.Context, localDir string, prefix string) error
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix remove todos os objetos sob o "diretório" prefix e
+	// retorna quantos foram removidos.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// dirPrefix normaliza o prefixo para terminar em "/", de modo que
+// "hls/12" não remova também "hls/123". Recusa prefixos vazios, que
+// apagariam o bucket inteiro.
+func dirPrefix(prefix string) (string, error) {
+	p := strings.Trim(prefix, "/")
+	if p == "" || p == "." {
+		return "", fmt.Errorf("prefixo inválido para remoção: %q", prefix)
+	}
+	return p + "/", nil
+}
+
+var (
+	_ Storage = (*S3Client)(nil)
+	_ Storage = (*LocalStorage)(nil)
+
+	_ StreamableStorage = (*S3Client)(nil)
+	_ StreamableStorage = (*LocalStorage)(nil)
+
+	_ HealthChecker = (*S3Client)(nil)
+	_ HealthChecker = (*LocalStorage)(nil)
+)
+
+const (
+	StorageBackendS3    = "s3"
+	StorageBackendLocal = "local"
+)
+
+// getStorageBackend lê <ROLE>_STORAGE_BACKEND, caindo para STORAGE_BACKEND e por fim "s3".
+func getStorageBackend(role string) string {
+	if b := os.Getenv(role + "_STORAGE_BACKEND"); b != "" {
+		return strings.ToLower(b)
+	}
+	if b := os.Getenv("STORAGE_BACKEND"); b != "" {
+		return strings.ToLower(b)
+	}
+	return StorageBackendS3
+}
+
+// getLocalStorageRoot lê <ROLE>_LOCAL_STORAGE_ROOT, caindo para LOCAL_STORAGE_ROOT.
+func getLocalStorageRoot(role string) string {
+	if d := os.Getenv(role + "_LOCAL_STORAGE_ROOT"); d != "" {
+		return d
+	}
+	return os.Getenv("LOCAL_STORAGE_ROOT")
+}
+
+// getS3Config lê a configuração global do S3 e aplica por cima
+// <ROLE>_AWS_BUCKET, <ROLE>_AWS_S3_REGION, <ROLE>_AWS_ENDPOINT e o par
+// <ROLE>_AWS_ACCESS_KEY_ID/<ROLE>_AWS_SECRET_ACCESS_KEY, para que originais
+// e saídas fiquem em buckets (ou contas) diferentes.
+func getS3Config(role string) S3Config {
+	c := loadS3ConfigFromEnv()
+	if b := os.Getenv(role + "_AWS_BUCKET"); b != "" {
+		c.Bucket = b
+	}
+	if r := os.Getenv(role + "_AWS_S3_REGION"); r != "" {
+		c.Region = r
+	}
+	if e := os.Getenv(role + "_AWS_ENDPOINT"); e != "" {
+		c.Endpoint = e
+	}
+	if k, s := os.Getenv(role+"_AWS_ACCESS_KEY_ID"), os.Getenv(role+"_AWS_SECRET_ACCESS_KEY"); k != "" && s != "" {
+		c.AccessKey, c.SecretKey = k, s
+	}
+	return c
+}
+
+func newStorage(role string) (Storage, error) {
+	switch backend := getStorageBackend(role); backend {
+	case StorageBackendS3:
+		return NewS3ClientFromConfig(getS3Config(role))
+	case StorageBackendLocal:
+		return NewLocalStorage(getLocalStorageRoot(role))
+	default:
+		return nil, fmt.Errorf("backend de storage desconhecido para %s: %s", strings.ToLower(role), backend)
+	}
+}
+
+// NewInputStorage cria o backend de onde os originais e watermarks são baixados.
+func NewInputStorage() (Storage, error) {
+	return newStorage("INPUT")
+}
+
+// NewOutputStorage cria o backend para onde as renditions HLS são enviadas.
+func NewOutputStorage() (Storage, error) {
+	return newStorage("OUTPUT")
+}
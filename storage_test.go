@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestGetS3Config(t *testing.T) {
+	t.Setenv("AWS_BUCKET", "global")
+	t.Setenv("AWS_S3_REGION", "us-east-1")
+	t.Setenv("AWS_ENDPOINT", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "global-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "global-secret")
+	t.Setenv("OUTPUT_AWS_BUCKET", "hls")
+	t.Setenv("OUTPUT_AWS_S3_REGION", "auto")
+	t.Setenv("OUTPUT_AWS_ENDPOINT", "https://r2.example.com")
+	t.Setenv("OUTPUT_AWS_ACCESS_KEY_ID", "output-key")
+	t.Setenv("OUTPUT_AWS_SECRET_ACCESS_KEY", "output-secret")
+	// Sem o secret, a chave do papel é ignorada.
+	t.Setenv("INPUT_AWS_ACCESS_KEY_ID", "input-key")
+
+	in := getS3Config("INPUT")
+	if in.Bucket != "global" || in.Region != "us-east-1" || in.Endpoint != "" || in.AccessKey != "global-key" || in.SecretKey != "global-secret" {
+		t.Errorf("INPUT = %+v", in)
+	}
+	out := getS3Config("OUTPUT")
+	if out.Bucket != "hls" || out.Region != "auto" || out.Endpoint != "https://r2.example.com" || out.AccessKey != "output-key" || out.SecretKey != "output-secret" {
+		t.Errorf("OUTPUT = %+v", out)
+	}
+}
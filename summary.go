@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hlsCodecs descreve o que o ffmpeg gera em todas as qualidades: H.264 High
+// 4.1 (avc1.640029) e AAC-LC (mp4a.40.2), no formato do atributo CODECS.
+const hlsCodecs = "avc1.640029,mp4a.40.2"
+
+// RenditionSummary descreve uma rendition publicada (ou mantida, no modo
+// repair). Segments, Bytes e Duration só existem para as codificadas pelo job.
+type RenditionSummary struct {
+	Quality    string  `json:"quality"`
+	Path       string  `json:"path"`
+	Resolution string  `json:"resolution"`
+	Bandwidth  int     `json:"bandwidth"`
+	Codecs     string  `json:"codecs"`
+	Segments   int     `json:"segments,omitempty"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	Existing   bool    `json:"existing,omitempty"`
+}
+
+// FailedRendition é uma qualidade que falhou, com a classificação do erro.
+type FailedRendition struct {
+	Quality   string `json:"quality"`
+	Error     string `json:"error"`
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// JobSummary é o resultado final do job, enviado depois dos callbacks por
+// qualidade: o Laravel atualiza a mídia de uma vez, sem contar callbacks.
+// Published indica se a master playlist em MasterPath aponta para as
+// renditions listadas (no modo versioned, só quando todas as qualidades
+// terminam sem falha).
+type JobSummary struct {
+	ConversionID      string             `json:"conversion_id"`
+	MediaID           int                `json:"media_id"`
+	BatchID           string             `json:"batch_id,omitempty"`
+	State             string             `json:"state"`
+	Published         bool               `json:"published"`
+	MasterPath        string             `json:"master_path,omitempty"`
+	Duration          float64            `json:"duration,omitempty"`
+	EncodeSeconds     float64            `json:"encode_seconds"`
+	ProcessingSeconds float64            `json:"processing_seconds"`
+	Renditions        []RenditionSummary `json:"renditions"`
+	Failed            []FailedRendition  `json:"failed,omitempty"`
+	Pending           []string           `json:"pending,omitempty"`
+}
+
+// JobCallbackPayload é o JobSummary no formato legado, com status
+// "job_finished".
+type JobCallbackPayload struct {
+	Status string `json:"status"`
+	JobSummary
+}
+
+// jobEventTypes mapeia o estado final do job para o tipo do evento.
+var jobEventTypes = map[string]string{
+	JobStateCompleted: EventJobCompleted,
+	JobStatePartial:   EventJobPartial,
+	JobStateFailed:    EventJobFailed,
+	JobStateCancelled: EventJobCancelled,
+}
+
+// renditionStats conta os segmentos gerados pelo ffmpeg em dir, o tamanho
+// total deles e a duração somando os #EXTINF da playlist.
+func renditionStats(dir, playlist string) (segments int, size int64, duration float64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".ts" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		segments++
+		size += info.Size()
+	}
+
+	f, err := os.Open(playlist)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		v, ok := strings.CutPrefix(scanner.Text(), "#EXTINF:")
+		if !ok {
+			continue
+		}
+		v, _, _ = strings.Cut(v, ",")
+		if d, err := strconv.ParseFloat(v, 64); err == nil {
+			duration += d
+		}
+	}
+	return segments, size, math.Round(duration*1000) / 1000, scanner.Err()
+}
+
+// recordRendition guarda as estatísticas da rendition codificada.
+func (j *ConversionJob) recordRendition(quality string, segments int, size int64, duration float64) {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	o := j.outcome(quality)
+	o.Segments = segments
+	o.Bytes = size
+	o.Duration = duration
+}
+
+// markPublished registra que a master playlist foi gravada com as renditions
+// do job.
+func (j *ConversionJob) markPublished() {
+	j.Mu.Lock()
+	j.Published = true
+	j.Mu.Unlock()
+}
+
+// newJobSummary monta o resultado final a partir do registro do histórico.
+func newJobSummary(rec *JobRecord) JobSummary {
+	payload := JobSummary{
+		ConversionID:  rec.ConversionID,
+		MediaID:       rec.MediaFileID,
+		BatchID:       rec.Request.BatchID,
+		State:         rec.State,
+		Published:     rec.Published,
+		EncodeSeconds: math.Round(rec.Phases[PhaseEncode]*1000) / 1000,
+		Renditions:    []RenditionSummary{},
+	}
+	if rec.Published {
+		payload.MasterPath = rec.MasterPath
+	}
+	if rec.StartedAt != nil && rec.FinishedAt != nil {
+		payload.ProcessingSeconds = math.Round(rec.FinishedAt.Sub(*rec.StartedAt).Seconds()*1000) / 1000
+	}
+
+	for _, o := range rec.Qualities {
+		switch o.Status {
+		case QualityCompleted, QualityExisting:
+			payload.Renditions = append(payload.Renditions, RenditionSummary{
+				Quality:    o.Quality,
+				Path:       o.S3Path,
+				Resolution: QualityResolution[o.Quality],
+				Bandwidth:  QualityBandwidth[o.Quality],
+				Codecs:     hlsCodecs,
+				Segments:   o.Segments,
+				Bytes:      o.Bytes,
+				Duration:   o.Duration,
+				Existing:   o.Status == QualityExisting,
+			})
+			payload.Duration = max(payload.Duration, o.Duration)
+		case QualityFailed:
+			payload.Failed = append(payload.Failed, FailedRendition{Quality: o.Quality, Error: o.Error, ErrorKind: o.ErrorKind})
+		default:
+			payload.Pending = append(payload.Pending, o.Quality)
+		}
+	}
+	if payload.Duration == 0 {
+		payload.Duration = float64(rec.Request.Duration)
+	}
+	return payload
+}
+
+// sendJobCallback envia o evento final do job (job.completed, job.partial,
+// job.failed ou job.cancelled) para o callback do tenant.
+func sendJobCallback(job *ConversionJob, rec *JobRecord) {
+	summary := newJobSummary(rec)
+	ev := newEvent(jobEventTypes[rec.State], job.ID, job.Request, summary)
+	ctx := withLogger(job.Ctx, jobLogger(job))
+	sendEvent(ctx, callbackFor(job.Request), ev, JobCallbackPayload{Status: "job_finished", JobSummary: summary})
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownTenant     = errors.New("tenant desconhecido")
+	ErrTenantConcurrency = errors.New("limite de jobs simultâneos do tenant atingido")
+	ErrQuotaExceeded     = errors.New("cota mensal de minutos do tenant esgotada")
+	ErrInvalidTenant     = errors.New("tenant inválido")
+)
+
+// validTenantName restringe o tenant ao que pode entrar em chaves de storage
+// via {tenant}.
+var validTenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Tenant é um produto interno que usa o conversor com bucket, credenciais,
+// layout de saída, callback e cotas próprios. Campos vazios herdam a
+// configuração global (AWS_*, CALLBACK_URL, ...).
+type Tenant struct {
+	Name              string   `json:"-"`
+	Bucket            string   `json:"bucket"`
+	Region            string   `json:"region"`
+	AccessKey         string   `json:"access_key"`
+	SecretKey         string   `json:"secret_key"`
+	Endpoint          string   `json:"endpoint"`
+	UsePathStyle      *bool    `json:"use_path_style,omitempty"`
+	RenditionTemplate string   `json:"rendition_template"`
+	MasterTemplate    string   `json:"master_template"`
+	PublishMode       string   `json:"publish_mode"`
+	CallbackURL       string   `json:"callback_url"`
+	CallbackSecret    string   `json:"callback_secret"`
+	CallbackEvents    []string `json:"callback_events,omitempty"`
+	CallbackLegacy    *bool    `json:"callback_legacy,omitempty"`
+	MaxConcurrentJobs int      `json:"max_concurrent_jobs"`
+	MonthlyMinutes    int      `json:"monthly_minutes"`
+}
+
+// TenantRegistry guarda os tenants de TENANTS_FILE e o consumo mensal de
+// minutos de cada um.
+type TenantRegistry struct {
+	tenants map[string]*Tenant
+	usage   *usageStore
+}
+
+// tenants é carregado por LoadTenants no start; vazio, todos os jobs usam a
+// configuração global.
+var tenants = &TenantRegistry{tenants: map[string]*Tenant{}, usage: newUsageStore("")}
+
+// LoadTenants lê TENANTS_FILE. Referências ${VAR} no arquivo são expandidas
+// a partir do ambiente, para que segredos não precisem ficar no arquivo.
+func LoadTenants() error {
+	path := os.Getenv("TENANTS_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+	var cfg struct {
+		Tenants map[string]*Tenant `json:"tenants"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return fmt.Errorf("erro ao interpretar %s: %w", path, err)
+	}
+
+	for name, t := range cfg.Tenants {
+		if t == nil {
+			return fmt.Errorf("tenant %q sem configuração", name)
+		}
+		t.Name = name
+		if t.PublishMode != "" && t.PublishMode != PublishInPlace && t.PublishMode != PublishVersioned {
+			return fmt.Errorf("tenant %q: publish_mode deve ser in_place ou versioned", name)
+		}
+		if err := validateEventFilter(t.CallbackEvents); err != nil {
+			return fmt.Errorf("tenant %q: %w", name, err)
+		}
+		if !validTenantName.MatchString(name) {
+			return fmt.Errorf("tenant %q: nome deve conter só letras, números, '-' e '_'", name)
+		}
+		// Sem bucket próprio, o tenant divide o storage global com os demais.
+		if t.Bucket == "" {
+			for _, tmpl := range []string{t.RenditionTemplate, t.MasterTemplate} {
+				if tmpl != "" && !strings.Contains(tmpl, "{tenant}") {
+					return fmt.Errorf("tenant %q: sem bucket próprio, os templates devem conter {tenant}", name)
+				}
+			}
+		}
+		if t.RenditionTemplate != "" || t.MasterTemplate != "" {
+			rendition, master := defaultRenditionTemplate, defaultMasterTemplate
+			if t.RenditionTemplate != "" {
+				rendition = t.RenditionTemplate
+			}
+			if t.MasterTemplate != "" {
+				master = t.MasterTemplate
+			}
+			if err := validateOutputTemplates(rendition, master); err != nil {
+				return fmt.Errorf("tenant %q: %w", name, err)
+			}
+		}
+	}
+
+	usage := newUsageStore(os.Getenv("TENANT_USAGE_FILE"))
+	if err := usage.load(); err != nil {
+		return err
+	}
+
+	tenants = &TenantRegistry{tenants: cfg.Tenants, usage: usage}
+	slog.Info("Tenants carregados", "component", "tenant", "path", path, "tenants", len(cfg.Tenants))
+	return nil
+}
+
+func (r *TenantRegistry) Configured() bool {
+	return len(r.tenants) > 0
+}
+
+// Lookup retorna o tenant pelo nome, ou nil se não estiver configurado.
+func (r *TenantRegistry) Lookup(name string) *Tenant {
+	if name == "" {
+		return nil
+	}
+	return r.tenants[name]
+}
+
+// Validate verifica se o tenant do request existe. Sem TENANTS_FILE o tenant
+// é só um rótulo de isolamento e qualquer valor é aceito.
+func (r *TenantRegistry) Validate(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !validTenantName.MatchString(name) {
+		return ErrInvalidTenant
+	}
+	if !r.Configured() {
+		return nil
+	}
+	if r.Lookup(name) == nil {
+		return ErrUnknownTenant
+	}
+	return nil
+}
+
+// jobMinutes é o consumo de um job: minutos de mídia vezes o número de
+// qualidades, arredondado para cima.
+func jobMinutes(req ConvertRequest) int {
+	return int(math.Ceil(float64(req.Duration) * float64(len(req.Qualities)) / 60))
+}
+
+// ReserveMinutes debita os minutos do job na cota mensal do tenant. Retorna
+// ErrQuotaExceeded se a cota não comportar o job.
+func (r *TenantRegistry) ReserveMinutes(req ConvertRequest) error {
+	t := r.Lookup(req.Tenant)
+	if t == nil || t.MonthlyMinutes <= 0 {
+		return nil
+	}
+	return r.usage.reserve(t.Name, jobMinutes(req), t.MonthlyMinutes)
+}
+
+// ReleaseMinutes devolve os minutos de um job que não chegou a ser aceito.
+func (r *TenantRegistry) ReleaseMinutes(req ConvertRequest) {
+	t := r.Lookup(req.Tenant)
+	if t == nil || t.MonthlyMinutes <= 0 {
+		return
+	}
+	r.usage.adjust(t.Name, -jobMinutes(req))
+}
+
+// SettleMinutes troca a reserva do job (reserved minutos, calculados pela
+// duration do request, que o cliente controla) pelo que ele de fato
+// codificou, medido nas playlists geradas. O acerto pode passar da cota: o
+// trabalho já foi feito, e os próximos jobs do tenant são recusados.
+func (r *TenantRegistry) SettleMinutes(job *ConversionJob, reserved int) {
+	t := r.Lookup(job.Request.Tenant)
+	if t == nil || t.MonthlyMinutes <= 0 {
+		return
+	}
+	if delta := job.encodedMinutes() - reserved; delta != 0 {
+		r.usage.adjust(t.Name, delta)
+	}
+}
+
+// encodedMinutes soma a duração das renditions codificadas pelo job, em
+// minutos arredondados para cima. Uma rendition sem medida conta a duration
+// do request.
+func (j *ConversionJob) encodedMinutes() int {
+	j.Mu.Lock()
+	defer j.Mu.Unlock()
+	var seconds float64
+	for _, o := range j.Outcomes {
+		if o.Status != QualityCompleted {
+			continue
+		}
+		if o.Duration > 0 {
+			seconds += o.Duration
+		} else {
+			seconds += float64(j.Request.Duration)
+		}
+	}
+	return int(math.Ceil(seconds / 60))
+}
+
+// secondsUntilNextMonth é o Retry-After de uma cota mensal esgotada.
+func secondsUntilNextMonth() int {
+	now := time.Now().UTC()
+	next := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	return int(next.Sub(now).Seconds()) + 1
+}
+
+// jobStorages cria os backends de entrada e saída do job. Tenants com bucket
+// próprio usam um cliente S3 com as suas credenciais para ambos.
+func jobStorages(req ConvertRequest) (input Storage, output Storage, err error) {
+	if t := tenants.Lookup(req.Tenant); t != nil && t.Bucket != "" {
+		client, err := NewS3ClientFromConfig(t.s3Config())
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage do tenant %s: %w", t.Name, err)
+		}
+		return client, client, nil
+	}
+
+	if input, err = NewInputStorage(); err != nil {
+		return nil, nil, fmt.Errorf("erro ao criar storage de entrada: %w", err)
+	}
+	if output, err = NewOutputStorage(); err != nil {
+		return nil, nil, fmt.Errorf("erro ao criar storage de saída: %w", err)
+	}
+	return input, output, nil
+}
+
+func (t *Tenant) s3Config() S3Config {
+	c := loadS3ConfigFromEnv()
+	c.Bucket = t.Bucket
+	if t.Region != "" {
+		c.Region = t.Region
+	}
+	if t.AccessKey != "" {
+		c.AccessKey = t.AccessKey
+		c.SecretKey = t.SecretKey
+	}
+	if t.Endpoint != "" {
+		c.Endpoint = t.Endpoint
+	}
+	if t.UsePathStyle != nil {
+		c.UsePathStyle = *t.UsePathStyle
+	}
+	return c
+}
+
+// CallbackTarget é para onde e com qual segredo os callbacks de um job são
+// assinados e enviados, quais eventos o assinante recebe (vazio: todos) e se
+// ele usa o formato legado.
+type CallbackTarget struct {
+	URL    string
+	Secret string
+	Events []string
+	Legacy bool
+}
+
+func callbackFor(req ConvertRequest) CallbackTarget {
+	target := CallbackTarget{
+		URL:    getCallbackURL(),
+		Secret: os.Getenv("CALLBACK_SIGNING_SECRET"),
+		Events: getCallbackEvents(),
+		Legacy: getCallbackLegacy(),
+	}
+	if t := tenants.Lookup(req.Tenant); t != nil {
+		if t.CallbackURL != "" {
+			target.URL = t.CallbackURL
+		}
+		if t.CallbackSecret != "" {
+			target.Secret = t.CallbackSecret
+		}
+		if t.CallbackEvents != nil {
+			target.Events = t.CallbackEvents
+		}
+		if t.CallbackLegacy != nil {
+			target.Legacy = *t.CallbackLegacy
+		}
+	}
+	return target
+}
+
+// usageStore contabiliza os minutos consumidos por tenant e mês (UTC). Com
+// TENANT_USAGE_FILE o consumo sobrevive a restarts e deploys.
+type usageStore struct {
+	path string
+	mu   sync.Mutex
+	// tenant -> "2006-01" -> minutos
+	minutes map[string]map[string]int
+}
+
+func newUsageStore(path string) *usageStore {
+	return &usageStore{path: path, minutes: make(map[string]map[string]int)}
+}
+
+func currentMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func (u *usageStore) load() error {
+	if u.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(u.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", u.path, err)
+	}
+	if err := json.Unmarshal(data, &u.minutes); err != nil {
+		return fmt.Errorf("erro ao interpretar %s: %w", u.path, err)
+	}
+	return nil
+}
+
+func (u *usageStore) reserve(tenant string, minutes, quota int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	month := currentMonth()
+	if u.minutes[tenant] == nil {
+		u.minutes[tenant] = make(map[string]int)
+	}
+	if u.minutes[tenant][month]+minutes > quota {
+		return ErrQuotaExceeded
+	}
+	u.minutes[tenant][month] += minutes
+	u.persist()
+	return nil
+}
+
+// adjust soma delta (negativo para devolver) ao consumo do mês, sem
+// conferir a cota.
+func (u *usageStore) adjust(tenant string, delta int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	month := currentMonth()
+	if u.minutes[tenant] == nil {
+		u.minutes[tenant] = make(map[string]int)
+	}
+	u.minutes[tenant][month] = max(0, u.minutes[tenant][month]+delta)
+	u.persist()
+}
+
+// persist grava o consumo em TENANT_USAGE_FILE. Deve ser chamado com u.mu
+// travado.
+func (u *usageStore) persist() {
+	if u.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(u.minutes, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(u.path), 0755); err == nil {
+			tmp := u.path + ".tmp"
+			if err = os.WriteFile(tmp, data, 0644); err == nil {
+				err = os.Rename(tmp, u.path)
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("Erro ao persistir consumo dos tenants", "component", "tenant", "path", u.path, "error", err.Error())
+	}
+}
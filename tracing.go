@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("hls-go")
+
+// initTracing configura a propagação W3C (traceparent/tracestate) e, quando
+// OTEL_TRACES_EXPORTER=otlp ou OTEL_EXPORTER_OTLP_ENDPOINT estiver definido,
+// exporta spans via OTLP/HTTP. Sem configuração o tracer global é no-op.
+// O exporter lê as variáveis padrão OTEL_EXPORTER_OTLP_*.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	if exporter == "none" || (exporter != "otlp" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := envOrDefault("OTEL_SERVICE_NAME", "hls-converter")
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	slog.Info("Exportando spans via OTLP", "component", "tracing", "service_name", serviceName)
+	return tp.Shutdown, nil
+}
+
+// endSpan registra o erro (se houver) e encerra o span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func jobAttributes(job *ConversionJob) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("hls.conversion_id", job.ID),
+		attribute.Int("hls.media_file_id", job.Request.MediaFileID),
+		attribute.String("hls.tenant", job.Request.Tenant),
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	defaultUploadConcurrency  = 8
+	defaultUploadMaxAttempts  = 4
+	defaultMultipartThreshold = 64 << 20
+	defaultMultipartPartSize  = 16 << 20
+	minMultipartPartSize      = 5 << 20
+)
+
+// UploadOptions controla o paralelismo, as tentativas e o multipart dos uploads.
+type UploadOptions struct {
+	Concurrency        int
+	MaxAttempts        int
+	MultipartThreshold int64
+	PartSize           int64
+}
+
+func getUploadOptions() UploadOptions {
+	opts := UploadOptions{
+		Concurrency:        getEnvInt("S3_UPLOAD_CONCURRENCY", defaultUploadConcurrency),
+		MaxAttempts:        getEnvInt("S3_UPLOAD_MAX_ATTEMPTS", defaultUploadMaxAttempts),
+		MultipartThreshold: int64(getEnvInt("S3_MULTIPART_THRESHOLD_MB", defaultMultipartThreshold>>20)) << 20,
+		PartSize:           int64(getEnvInt("S3_MULTIPART_PART_SIZE_MB", defaultMultipartPartSize>>20)) << 20,
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.PartSize < minMultipartPartSize {
+		opts.PartSize = minMultipartPartSize
+	}
+	return opts
+}
+
+// withRetry executa fn até maxAttempts vezes com backoff exponencial,
+// repetindo só os erros que isTransientError reconhece como transitórios.
+func withRetry(ctx context.Context, maxAttempts int, what string, fn func() error) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == maxAttempts || !isTransientError(err) {
+			break
+		}
+		ctxLogger(ctx, "s3").Warn("Tentativa falhou, tentando novamente", "phase", PhaseUpload, "key", what, "attempt", attempt, "max_attempts", maxAttempts, "retry_in", backoff.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 8*time.Second {
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// sha256Section calcula o checksum SHA-256 de um trecho do arquivo em base64,
+// no formato esperado pelo S3 para verificação de integridade.
+func sha256Section(file *os.File, offset, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, offset, size)); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *S3Client) putObject(ctx context.Context, localPath string, s3Path string, contentType string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("erro ao ler arquivo %s: %w", localPath, err)
+	}
+	checksum, err := sha256Section(file, 0, info.Size())
+	if err != nil {
+		return fmt.Errorf("erro ao calcular checksum de %s: %w", localPath, err)
+	}
+
+	// O S3 rejeita o objeto se o SHA-256 recebido não bater com o enviado.
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(s3Path),
+		Body:              io.NewSectionReader(file, 0, info.Size()),
+		ContentLength:     aws.Int64(info.Size()),
+		ContentType:       aws.String(contentType),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:    aws.String(checksum),
+	})
+	return err
+}
+
+func (s *S3Client) uploadMultipart(ctx context.Context, localPath string, s3Path string, contentType string, size int64) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	opts := s.uploadOpts
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(s3Path),
+		ContentType:       aws.String(contentType),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	abort := func() {
+		// Usa um contexto próprio: o do job pode já ter sido cancelado.
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := s.client.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(s3Path),
+			UploadId: uploadID,
+		}); err != nil {
+			ctxLogger(ctx, "s3").Error("Erro ao abortar multipart upload", "phase", PhaseUpload, "key", s3Path, "error", err.Error())
+		}
+	}
+
+	partCount := int((size + opts.PartSize - 1) / opts.PartSize)
+	parts := make([]types.CompletedPart, partCount)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, opts.Concurrency)
+
+	for i := 0; i < partCount; i++ {
+		offset := int64(i) * opts.PartSize
+		partSize := opts.PartSize
+		if offset+partSize > size {
+			partSize = size - offset
+		}
+		partNumber := int32(i + 1)
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(idx int, offset, partSize int64, partNumber int32) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			checksum, err := sha256Section(file, offset, partSize)
+			if err == nil {
+				err = withRetry(ctx, opts.MaxAttempts, fmt.Sprintf("%s (parte %d)", s3Path, partNumber), func() error {
+					out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+						Bucket:            aws.String(s.bucket),
+						Key:               aws.String(s3Path),
+						UploadId:          uploadID,
+						PartNumber:        aws.Int32(partNumber),
+						Body:              io.NewSectionReader(file, offset, partSize),
+						ContentLength:     aws.Int64(partSize),
+						ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+						ChecksumSHA256:    aws.String(checksum),
+					})
+					if err != nil {
+						return err
+					}
+					if out.ChecksumSHA256 != nil && *out.ChecksumSHA256 != checksum {
+						return fmt.Errorf("checksum divergente na parte %d", partNumber)
+					}
+					parts[idx] = types.CompletedPart{
+						ETag:           out.ETag,
+						PartNumber:     aws.Int32(partNumber),
+						ChecksumSHA256: aws.String(checksum),
+					}
+					return nil
+				})
+			}
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i, offset, partSize, partNumber)
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		abort()
+		return fmt.Errorf("erro ao enviar partes: %w", firstErr)
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s3Path),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+		return fmt.Errorf("erro ao concluir multipart upload: %w", err)
+	}
+
+	ctxLogger(ctx, "s3").Info("Multipart upload concluído", "phase", PhaseUpload, "key", s3Path, "parts", partCount, "bytes", size)
+	return nil
+}
+
+type uploadItem struct {
+	localPath string
+	s3Key     string
+}
+
+// uploadFiles envia os arquivos em paralelo, limitado por opts.Concurrency.
+// Playlists (.m3u8) são enviadas por último para que nunca referenciem
+// segmentos que ainda não existem no bucket.
+func (s *S3Client) uploadFiles(ctx context.Context, items []uploadItem) error {
+	sort.SliceStable(items, func(i, j int) bool {
+		return !isPlaylist(items[i].localPath) && isPlaylist(items[j].localPath)
+	})
+
+	split := sort.Search(len(items), func(i int) bool { return isPlaylist(items[i].localPath) })
+	if err := s.uploadParallel(ctx, items[:split]); err != nil {
+		return err
+	}
+	return s.uploadParallel(ctx, items[split:])
+}
+
+func (s *S3Client) uploadParallel(ctx context.Context, items []uploadItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan uploadItem)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	workers := s.uploadOpts.Concurrency
+	if workers > len(items) {
+		workers = len(items)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := s.Upload(ctx, item.localPath, item.s3Key); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case work <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func isPlaylist(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".m3u8")
+}